@@ -2,19 +2,30 @@ package db
 
 // DB first writes to wal then mmt
 // There can be a single avtive mt and several waiting to flush mt.
+// TODO column families: move activeMMT/cache (and later tables, compaction options, comparator) into a
+// per family struct, keeping wal and version shared so a batch can span families. Needs Put/Get first.
 type DB struct {
 	activeMMT *MemTable
 	cache     MemCache
 	wal       *Wal
 	version   uint64
+	//TODO tableCache, an LRU bounding the open TableReaders that reopens them lazily and keeps the
+	//parsed footer/index/filter. There is no TableReader yet.
+	//TODO cmp types.Comparator, passed to the memtables via datastructures.WithComparator. Its Name has to be
+	//written to the MANIFEST so opening with a different comparator fails, there is no MANIFEST yet.
 }
 
 // func NewDB() {
 
 // }
 
+// TODO OpenReadOnly(dir), a frozen view that doesn't take the LOCK file, and OpenSecondary(dir) that
+// catches up periodically by replaying new MANIFEST edits and WAL tails. Needs NewDB and the on disk formats.
+
 // func (db *DB) Get(key []byte) (types.VersionedValue, bool) {
 // 	//try for memtable first, then memcache then files, can be concurrent with respecting this error for the reply
+// 	//TODO check a datastructures.RowCache before the files and Insert what they return for a read at the
+// 	//latest version, Put/Delete must Invalidate the key.
 // }
 
 // func (db *DB) Put(key, value []byte) error {
@@ -22,3 +33,25 @@ type DB struct {
 // 	//in case memtable is full is should create new and swap
 // 	//put in memtable for now we should use a global rw lock
 // }
+
+// func (db *DB) CompactRange(ctx context.Context, start, end []byte, opts CompactRangeOptions) error {
+// 	//TODO there are no table files or compaction strategies yet, Flush doesn't write anything.
+// 	//once they exist: pick the files overlapping [start, end) on every level, compact them with
+// 	//at most opts.MaxConcurrency workers, stop on ctx.Done() and report bytes/files in and out
+// 	//through opts.Progress.
+// 	//when the output is the bottom level, sample values into compression.TrainDictionary, compress the
+// 	//blocks with its Compressor, and write its Raw to the meta block and its Properties to the table
+// 	//properties, there is no table writer yet.
+// }
+
+// TODO crash consistency test, once there is an Open and a WAL that writes: run seeded random Put/Delete/Batch
+// workloads on vfs.NewFaultyFS(vfs.NewMemFS(), ...), cut power at random Sync/SyncDir points with MemFS.CrashClone,
+// reopen and check that every acknowledged write survived and no unacknowledged batch is half applied.
+
+// TODO ParanoidChecks option: verify probability.VerifyChecksum on every block read and compaction input,
+// plus a background scrubber re-reading the table files at a bytes/sec rate. A corruption goes to an event
+// listener and moves the db to a degraded read only state. Needs table files and DB options first.
+
+// TODO Repair(dir): rebuild the MANIFEST by scanning the table files (checksums and key ranges), replaying
+// the WAL segments into new tables and moving unreadable files to lost/, all through a vfs.FS.
+// There is no MANIFEST, table or WAL format yet.
@@ -2,24 +2,44 @@ package db
 
 import (
 	"fmt"
-	"os"
 	"sync/atomic"
 
 	"github.com/cloudnoize/el_gokv/src/plasma/datastructures"
 	"github.com/cloudnoize/el_gokv/src/plasma/types"
 	"github.com/cloudnoize/el_gokv/src/plasma/utils"
+	"github.com/cloudnoize/el_gokv/src/plasma/vfs"
 )
 
 type MemTable struct {
-	store         types.KVDB
-	byteSize      uint64
+	// store is dropped once flushed so its memory (the whole arena for an arena memtable) can be
+	// collected while the MemTable itself is still referenced
+	store         atomic.Pointer[memStore]
 	latestVersion uint64
 	isFlushed     atomic.Bool
 	isClosed      atomic.Bool
 }
 
-func NewMemTable(estimateCap uint64) *MemTable {
-	return &MemTable{store: datastructures.NewMapNSkip(estimateCap)}
+type memStore struct {
+	types.KVDB
+}
+
+func newMemTable(store types.KVDB) *MemTable {
+	m := &MemTable{}
+	m.store.Store(&memStore{store})
+	return m
+}
+
+// NewMemTable keeps the versions in a skiplist configured by opts, e.g. datastructures.WithRetention
+// to prune the versions no snapshot reads.
+func NewMemTable(estimateCap uint64, opts ...datastructures.SkipListOption) *MemTable {
+	return newMemTable(datastructures.NewMapNSkip(estimateCap, opts...))
+}
+
+// NewArenaMemTable keeps the keys, values and nodes in one arena of arenaSize bytes, Put fails
+// with datastructures.ErrArenaFull once it's full and the memtable should be flushed.
+// opts can set the comparator and the level source, not a retention policy.
+func NewArenaMemTable(arenaSize uint32, opts ...datastructures.SkipListOption) *MemTable {
+	return newMemTable(datastructures.NewArenaSkipList(arenaSize, opts...))
 }
 
 func (m *MemTable) Put(kv *types.KV) (uint64, error) {
@@ -27,34 +47,59 @@ func (m *MemTable) Put(kv *types.KV) (uint64, error) {
 		return 0, fmt.Errorf("trying to insert to inactive memtable")
 	}
 	utils.Assert(kv.Version > m.latestVersion, "Input version is not higher than current version")
+	store := m.store.Load()
+	if store == nil {
+		return 0, fmt.Errorf("trying to insert to inactive memtable")
+	}
 	m.latestVersion = kv.Version
-	m.byteSize += uint64(len(kv.Key) + len(kv.Value))
-	m.store.Put(kv)
-	return m.byteSize, nil
+	if err := store.Put(kv); err != nil {
+		return 0, err
+	}
+	return m.ByteSize(), nil
 }
 
 func (m *MemTable) Get(key []byte) (types.VersionedValue, bool) {
-	if m.isFlushed.Load() {
+	store := m.store.Load()
+	if store == nil {
 		return types.VersionedValue{}, false
 	}
-	ret, ok := m.store.Get(key)
+	ret, ok := store.Get(key)
 	return ret, ok
 }
 
 func (m *MemTable) Size() uint64 {
-	return m.store.Size()
+	if store := m.store.Load(); store != nil {
+		return store.Size()
+	}
+	return 0
 }
 
+// ByteSize is the memory the memtable holds, keys and values plus the overhead of the store,
+// 0 once flushed.
 func (m *MemTable) ByteSize() uint64 {
-	return m.byteSize
+	if store := m.store.Load(); store != nil {
+		return store.MemoryUsage()
+	}
+	return 0
 }
 
-func (m *MemTable) Flush(f *os.File) error {
-	if m.isFlushed.Load() {
+// TODO Flush only marks the memtable, nothing is written to f yet. Once tables exist, block reads
+// should go through a datastructures.BlockCache keyed by file number + block offset, with the
+// index/filter blocks inserted pinned and unpinned when the table is closed.
+// TODO the table writer should write data blocks with compression.EncodeBlock using
+// LevelCompression.ForLevel of the output level, and Record them in compression.Stats.
+func (m *MemTable) Flush(f vfs.File) error {
+	store := m.store.Load()
+	if m.isFlushed.Load() || store == nil {
 		return fmt.Errorf("memtable is already flushed")
 	}
 
+	m.isClosed.Store(true)
+	// Seal waits for the skiplist to hold every key
+	//TODO write the sealed iterator to f
+	store.Seal()
 	m.isFlushed.Store(true)
+	m.store.Store(nil)
 	return nil
 }
 
@@ -63,6 +108,6 @@ type MemCache struct {
 	cap    uint64
 }
 
-// func (m *MemTable) Flush(f *os.File) bool {
+// func (m *MemTable) Flush(f vfs.File) bool {
 
 // }
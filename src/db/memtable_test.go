@@ -2,9 +2,14 @@ package db
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/cloudnoize/el_gokv/src/plasma/datastructures"
 	"github.com/cloudnoize/el_gokv/src/plasma/types"
+	"github.com/cloudnoize/el_gokv/src/plasma/vfs"
 )
 
 func TestMemTable_putAndGet(t *testing.T) {
@@ -12,13 +17,13 @@ func TestMemTable_putAndGet(t *testing.T) {
 	key := []byte("key")
 	version := 1
 	value := []byte("Val")
-	expectedSize := uint64(len(key) + len(value))
+	minSize := uint64(len(key) + len(value))
 	mmt.Put(&types.KV{Key: key, Value: value, Version: uint64(version)})
 	if mmt.Size() != 1 {
 		t.Fatalf("Expected size 1 , actual %d", mmt.Size())
 	}
-	if mmt.ByteSize() != expectedSize {
-		t.Fatalf("Expected byte size %d , actual %d", expectedSize, mmt.ByteSize())
+	if mmt.ByteSize() <= minSize {
+		t.Fatalf("Expected byte size above %d , actual %d", minSize, mmt.ByteSize())
 	}
 	if v, ok := mmt.Get([]byte("doesn't exist")); ok {
 		t.Fatalf("Expected not to get a value but received %x", v)
@@ -35,3 +40,88 @@ func TestMemTable_putAndGet(t *testing.T) {
 		t.Fatalf("exepected to get %d but go %d", version, out.Version)
 	}
 }
+
+func TestMemTable_putAfterFlush(t *testing.T) {
+	mmt := NewMemTable(1024)
+	if _, err := mmt.Put(&types.KV{Key: []byte("key"), Value: []byte("Val"), Version: 1}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	f, err := vfs.NewMemFS().Create("000001.sst")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := mmt.Flush(f); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := mmt.Put(&types.KV{Key: []byte("key"), Value: []byte("Val2"), Version: 2}); err == nil {
+		t.Fatalf("expected put after flush to fail")
+	}
+	if err := mmt.Flush(f); err == nil {
+		t.Fatalf("expected second flush to fail")
+	}
+}
+
+func TestMemTable_arenaDroppedAfterFlush(t *testing.T) {
+	mmt := NewArenaMemTable(1 << 16)
+	for v := uint64(1); v <= 10; v++ {
+		if _, err := mmt.Put(&types.KV{Key: []byte(fmt.Sprintf("key-%d", v)), Value: []byte("Val"), Version: v}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if mmt.Size() != 10 || mmt.ByteSize() == 0 {
+		t.Fatalf("expected 10 keys got %d, byte size %d", mmt.Size(), mmt.ByteSize())
+	}
+	if got, ok := mmt.Get([]byte("key-3")); !ok || !bytes.Equal(got.Value, []byte("Val")) {
+		t.Fatalf("expected Val got %s", got.Value)
+	}
+	f, _ := vfs.NewMemFS().Create("000001.sst")
+	if err := mmt.Flush(f); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if mmt.Size() != 0 || mmt.ByteSize() != 0 {
+		t.Fatalf("expected the arena to be dropped, size %d byte size %d", mmt.Size(), mmt.ByteSize())
+	}
+	if _, ok := mmt.Get([]byte("key-3")); ok {
+		t.Fatalf("expected no get after flush")
+	}
+}
+
+func TestMemTable_arenaFull(t *testing.T) {
+	mmt := NewArenaMemTable(512)
+	var err error
+	for v := uint64(1); err == nil; v++ {
+		_, err = mmt.Put(&types.KV{Key: []byte(fmt.Sprintf("key-%d", v)), Value: []byte("Val"), Version: v})
+	}
+	if !errors.Is(err, datastructures.ErrArenaFull) {
+		t.Fatalf("expected ErrArenaFull got %v", err)
+	}
+}
+
+func TestMemTable_retentionShrinks(t *testing.T) {
+	value := bytes.Repeat([]byte("v"), 1024)
+	put := func(mmt *MemTable) {
+		for v := uint64(1); v <= 100; v++ {
+			if _, err := mmt.Put(&types.KV{Key: []byte("key"), Value: value, Version: v}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+	}
+	all := NewMemTable(1024)
+	put(all)
+	pruned := NewMemTable(1024, datastructures.WithRetention(datastructures.RetentionPolicy{KeepLast: 2}))
+	put(pruned)
+	// the skiplist gets the versions in the background
+	for deadline := time.Now().Add(5 * time.Second); pruned.Size() > 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if all.Size() != 100 || pruned.Size() != 2 {
+		t.Fatalf("expected 100 and 2 versions got %d and %d", all.Size(), pruned.Size())
+	}
+	// both have the same fixed overhead, the difference is the 98 pruned values
+	if all.ByteSize()-pruned.ByteSize() < 98*uint64(len(value)) {
+		t.Fatalf("expected the pruned values to be released, %d vs %d", pruned.ByteSize(), all.ByteSize())
+	}
+	if got, ok := pruned.Get([]byte("key")); !ok || got.Version != 100 {
+		t.Fatalf("expected version 100 got %d", got.Version)
+	}
+}
@@ -1,5 +1,10 @@
 package db
 
+// TODO every record (and table block, filter and manifest entry) should carry a probability.Checksum
+// and be checked with probability.VerifyChecksum on read, failing with a CorruptionError.
+// TODO once records are written, write an encryption.EncryptionProvider header at the start of the file
+// and Seal every record (and table block / manifest entry) with its offset. Compaction should rewrite
+// the files NeedsRotation reports.
 type Wal struct {
 	//last version that was flushed to file, i.e. everything above it is volatile
 	waterMark uint64
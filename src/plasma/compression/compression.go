@@ -0,0 +1,158 @@
+package compression
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// maxRawBlock bounds what a corrupt block can make Decompress allocate, blocks are a few KB.
+// It's a var so tests can lower it.
+var maxRawBlock = 1 << 30
+
+var (
+	ErrUnknownCodec = errors.New("compression: unknown codec")
+	ErrCorruptBlock = errors.New("compression: corrupt block")
+	// ErrMissingDictionary is returned for a dictionary block read without Dictionary.DecodeBlock
+	ErrMissingDictionary = errors.New("compression: block needs the table dictionary")
+)
+
+// Codec is the byte in a block trailer that says how the block was compressed.
+// The values are on disk, don't renumber them.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecLZ
+	CodecFlate
+	// CodecFlateDict is flate against the table's Dictionary, the block can only be read with it
+	CodecFlateDict
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecLZ:
+		return "lz"
+	case CodecFlate:
+		return "flate"
+	case CodecFlateDict:
+		return "flate-dict"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// Compressor compresses table blocks, all codecs are pure Go.
+// Compress and Decompress append to dst so the caller can reuse buffers.
+type Compressor interface {
+	Codec() Codec
+	Compress(dst, src []byte) []byte
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var (
+	NoCompression Compressor = noCompressor{}
+	// LZ is the fast one, an lz77 in the spirit of lz4/snappy
+	LZ Compressor = lzCompressor{}
+	// Flate is the compact one, deflate from the standard library
+	Flate Compressor = newFlateCompressor(flate.BestCompression)
+)
+
+func Lookup(c Codec) (Compressor, error) {
+	switch c {
+	case CodecNone:
+		return NoCompression, nil
+	case CodecLZ:
+		return LZ, nil
+	case CodecFlate:
+		return Flate, nil
+	case CodecFlateDict:
+		return nil, ErrMissingDictionary
+	}
+	return nil, fmt.Errorf("%w %d", ErrUnknownCodec, byte(c))
+}
+
+// EncodeBlock appends block compressed with c and the codec byte to dst. Like leveldb, a block that
+// doesn't shrink by at least 1/8 is stored raw, it isn't worth paying the decompression on every read.
+func EncodeBlock(c Compressor, dst, block []byte) []byte {
+	start := len(dst)
+	codec := c.Codec()
+	dst = c.Compress(dst, block)
+	if codec != CodecNone && len(dst)-start >= len(block)-len(block)/8 {
+		dst = append(dst[:start], block...)
+		codec = CodecNone
+	}
+	return append(dst, byte(codec))
+}
+
+// DecodeBlock appends the raw block of data, as written by EncodeBlock, to dst.
+func DecodeBlock(dst, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: missing codec byte", ErrCorruptBlock)
+	}
+	c, err := Lookup(Codec(data[len(data)-1]))
+	if err != nil {
+		return nil, err
+	}
+	return c.Decompress(dst, data[:len(data)-1])
+}
+
+// LevelCompression is the compressor of each level, levels past the end use the last one.
+type LevelCompression []Compressor
+
+// DefaultLevelCompression keeps L0-L5 fast to write and compacts the bottom level.
+var DefaultLevelCompression = LevelCompression{LZ, LZ, LZ, LZ, LZ, LZ, Flate}
+
+func (l LevelCompression) ForLevel(level int) Compressor {
+	if len(l) == 0 {
+		return NoCompression
+	}
+	return l[min(level, len(l)-1)]
+}
+
+// Stats counts the raw and compressed bytes of the blocks written to each level.
+type Stats struct {
+	levels []levelStats
+}
+
+type levelStats struct {
+	raw        atomic.Uint64
+	compressed atomic.Uint64
+}
+
+func NewStats(numLevels int) *Stats {
+	return &Stats{levels: make([]levelStats, max(numLevels, 1))}
+}
+
+// Record is called by the table writer for every block, compressed includes the codec byte.
+func (s *Stats) Record(level, raw, compressed int) {
+	l := &s.levels[min(level, len(s.levels)-1)]
+	l.raw.Add(uint64(raw))
+	l.compressed.Add(uint64(compressed))
+}
+
+// Ratio is raw/compressed bytes of the level, 0 before anything was written to it.
+func (s *Stats) Ratio(level int) float64 {
+	l := &s.levels[min(level, len(s.levels)-1)]
+	compressed := l.compressed.Load()
+	if compressed == 0 {
+		return 0
+	}
+	return float64(l.raw.Load()) / float64(compressed)
+}
+
+type noCompressor struct{}
+
+func (noCompressor) Codec() Codec {
+	return CodecNone
+}
+
+func (noCompressor) Compress(dst, src []byte) []byte {
+	return append(dst, src...)
+}
+
+func (noCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
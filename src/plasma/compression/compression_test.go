@@ -0,0 +1,169 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+func blocks() map[string][]byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	random := make([]byte, 4096)
+	for i := range random {
+		random[i] = byte(r.Uint32())
+	}
+	var kvs bytes.Buffer
+	for i := range 200 {
+		fmt.Fprintf(&kvs, "user_key_%08d:value_%d;", i, i%7)
+	}
+	return map[string][]byte{
+		"empty":  {},
+		"short":  []byte("abc"),
+		"repeat": bytes.Repeat([]byte("a"), 5000),
+		"kvs":    kvs.Bytes(),
+		"random": random,
+	}
+}
+
+func TestCompressor_RoundTrip(t *testing.T) {
+	for _, c := range []Compressor{NoCompression, LZ, Flate} {
+		for name, block := range blocks() {
+			prefix := []byte("prefix")
+			compressed := c.Compress(bytes.Clone(prefix), block)
+			if !bytes.HasPrefix(compressed, prefix) {
+				t.Fatalf("%s %s: expected Compress to append to dst", c.Codec(), name)
+			}
+			got, err := c.Decompress(nil, compressed[len(prefix):])
+			if err != nil {
+				t.Fatalf("%s %s: %v", c.Codec(), name, err)
+			}
+			if !bytes.Equal(got, block) {
+				t.Fatalf("%s %s: round trip mismatch", c.Codec(), name)
+			}
+		}
+	}
+}
+
+func TestEncodeBlock_CodecByte(t *testing.T) {
+	kvs := blocks()["kvs"]
+	for _, c := range []Compressor{LZ, Flate} {
+		data := EncodeBlock(c, nil, kvs)
+		if Codec(data[len(data)-1]) != c.Codec() {
+			t.Fatalf("expected codec %s got %s", c.Codec(), Codec(data[len(data)-1]))
+		}
+		if len(data) >= len(kvs) {
+			t.Fatalf("%s: expected the block to shrink, %d >= %d", c.Codec(), len(data), len(kvs))
+		}
+		got, err := DecodeBlock(nil, data)
+		if err != nil || !bytes.Equal(got, kvs) {
+			t.Fatalf("%s: decode failed %v", c.Codec(), err)
+		}
+	}
+
+	// random bytes don't compress so they are stored raw
+	random := blocks()["random"]
+	data := EncodeBlock(LZ, nil, random)
+	if Codec(data[len(data)-1]) != CodecNone {
+		t.Fatalf("expected an incompressible block to be stored raw")
+	}
+	if got, err := DecodeBlock(nil, data); err != nil || !bytes.Equal(got, random) {
+		t.Fatalf("raw decode failed %v", err)
+	}
+}
+
+func TestDecodeBlock_Errors(t *testing.T) {
+	if _, err := DecodeBlock(nil, []byte("block\x09")); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("expected ErrUnknownCodec got %v", err)
+	}
+	if _, err := DecodeBlock(nil, nil); !errors.Is(err, ErrCorruptBlock) {
+		t.Fatalf("expected ErrCorruptBlock got %v", err)
+	}
+	for _, c := range []Compressor{LZ, Flate} {
+		data := EncodeBlock(c, nil, blocks()["kvs"])
+		data[len(data)/2] ^= 0xff
+		data = append(data[:len(data)/2+1], data[len(data)-1])
+		if _, err := DecodeBlock(nil, data); !errors.Is(err, ErrCorruptBlock) {
+			t.Fatalf("%s: expected ErrCorruptBlock got %v", c.Codec(), err)
+		}
+	}
+}
+
+func TestLevelCompression_ForLevel(t *testing.T) {
+	if c := (LevelCompression{}).ForLevel(3); c != NoCompression {
+		t.Fatalf("expected no compression for an empty config got %s", c.Codec())
+	}
+	if c := DefaultLevelCompression.ForLevel(0); c != LZ {
+		t.Fatalf("expected lz on L0 got %s", c.Codec())
+	}
+	if c := DefaultLevelCompression.ForLevel(6); c != Flate {
+		t.Fatalf("expected flate on the bottom level got %s", c.Codec())
+	}
+	if c := DefaultLevelCompression.ForLevel(10); c != Flate {
+		t.Fatalf("expected deeper levels to reuse the last codec got %s", c.Codec())
+	}
+}
+
+func TestStats_Ratio(t *testing.T) {
+	s := NewStats(3)
+	if r := s.Ratio(0); r != 0 {
+		t.Fatalf("expected 0 before any block got %f", r)
+	}
+	kvs := blocks()["kvs"]
+	for level := range 3 {
+		data := EncodeBlock(DefaultLevelCompression.ForLevel(level*3), nil, kvs)
+		s.Record(level, len(kvs), len(data))
+	}
+	if s.Ratio(0) <= 1 || s.Ratio(2) <= 1 {
+		t.Fatalf("expected a ratio over 1 got %f %f", s.Ratio(0), s.Ratio(2))
+	}
+	if s.Ratio(2) <= s.Ratio(1) {
+		t.Fatalf("expected flate on the bottom level to beat lz, %f <= %f", s.Ratio(2), s.Ratio(1))
+	}
+}
+
+func TestDecompress_Limit(t *testing.T) {
+	defer func(limit int) { maxRawBlock = limit }(maxRawBlock)
+	zeros := make([]byte, 64*1024)
+	for _, c := range []Compressor{LZ, Flate} {
+		data := EncodeBlock(c, nil, zeros)
+		maxRawBlock = len(zeros) - 1
+		if _, err := DecodeBlock(nil, data); !errors.Is(err, ErrCorruptBlock) {
+			t.Fatalf("%s: expected ErrCorruptBlock over the limit got %v", c.Codec(), err)
+		}
+		maxRawBlock = len(zeros)
+		if got, err := DecodeBlock(nil, data); err != nil || len(got) != len(zeros) {
+			t.Fatalf("%s: expected the block at the limit to decode, %v", c.Codec(), err)
+		}
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	kvs := blocks()["kvs"]
+	for _, c := range []Compressor{LZ, Flate} {
+		b.Run(c.Codec().String(), func(b *testing.B) {
+			b.SetBytes(int64(len(kvs)))
+			b.ReportAllocs()
+			var dst []byte
+			for b.Loop() {
+				dst = c.Compress(dst[:0], kvs)
+			}
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	kvs := blocks()["kvs"]
+	for _, c := range []Compressor{LZ, Flate} {
+		compressed := c.Compress(nil, kvs)
+		b.Run(c.Codec().String(), func(b *testing.B) {
+			b.SetBytes(int64(len(kvs)))
+			b.ReportAllocs()
+			dst := make([]byte, 0, len(kvs))
+			for b.Loop() {
+				dst, _ = c.Decompress(dst[:0], compressed)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package compression
+
+import (
+	"compress/flate"
+	"container/heap"
+	"strconv"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
+)
+
+const (
+	// MaxDictionarySize is the flate window, older bytes of a dictionary can't be referenced
+	MaxDictionarySize = 32 * 1024
+	// training picks segments of dictSegment bytes, starting every dictStep bytes of a sample,
+	// by how many samples share their dictGram byte grams
+	dictGram    = 8
+	dictSegment = 64
+	dictStep    = 16
+)
+
+// Dictionary is a shared flate dictionary for the blocks of a table, trained from sampled values so small
+// documents that compress badly one block at a time share their common parts. Raw goes to the table's meta
+// block and Properties to its table properties.
+type Dictionary struct {
+	Raw []byte
+	// ID is the crc32c of Raw, so a reader can tell its dictionary matches the one the blocks were written with
+	ID uint32
+	// Samples is the number of samples it was trained from, 0 for a dictionary read back from a table
+	Samples    int
+	compressor *flateCompressor
+}
+
+// NewDictionary wraps a dictionary read from a table meta block.
+func NewDictionary(raw []byte) *Dictionary {
+	return &Dictionary{Raw: raw, ID: probability.CRC32C(raw), compressor: newFlateDictCompressor(flate.BestCompression, raw)}
+}
+
+// Compressor compresses blocks against d, e.g. as the bottom entry of a LevelCompression.
+func (d *Dictionary) Compressor() Compressor {
+	return d.compressor
+}
+
+// DecodeBlock is DecodeBlock for the blocks of a table written with d.
+func (d *Dictionary) DecodeBlock(dst, data []byte) ([]byte, error) {
+	if len(data) > 0 && Codec(data[len(data)-1]) == CodecFlateDict {
+		return d.compressor.Decompress(dst, data[:len(data)-1])
+	}
+	return DecodeBlock(dst, data)
+}
+
+// Properties are the table properties that record the dictionary.
+func (d *Dictionary) Properties() map[string]string {
+	return map[string]string{
+		"compression.dictionary.id":      strconv.FormatUint(uint64(d.ID), 16),
+		"compression.dictionary.size":    strconv.Itoa(len(d.Raw)),
+		"compression.dictionary.samples": strconv.Itoa(d.Samples),
+	}
+}
+
+type dictSegmentRef struct {
+	sample, start, end int
+	score              int
+}
+
+type segmentHeap []dictSegmentRef
+
+func (h segmentHeap) Len() int           { return len(h) }
+func (h segmentHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h segmentHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *segmentHeap) Push(x any)        { *h = append(*h, x.(dictSegmentRef)) }
+func (h *segmentHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// TrainDictionary builds a dictionary of up to maxSize bytes from samples, e.g. values sampled by the
+// compaction to the bottom level. It greedily picks the segments whose grams are shared by the most samples,
+// a picked gram no longer counts for the rest, and puts the best segments last where flate references
+// them with the shortest distances.
+func TrainDictionary(samples [][]byte, maxSize int) *Dictionary {
+	maxSize = min(maxSize, MaxDictionarySize)
+	// a gram counts once per sample, what matters is how many blocks can reference it
+	freq := make(map[string]int)
+	for _, s := range samples {
+		seen := make(map[string]struct{})
+		for i := 0; i+dictGram <= len(s); i++ {
+			g := string(s[i : i+dictGram])
+			if _, ok := seen[g]; !ok {
+				seen[g] = struct{}{}
+				freq[g]++
+			}
+		}
+	}
+	score := func(seg dictSegmentRef) int {
+		s := samples[seg.sample]
+		total := 0
+		seen := make(map[string]struct{})
+		for i := seg.start; i+dictGram <= seg.end; i++ {
+			g := string(s[i : i+dictGram])
+			if _, ok := seen[g]; ok {
+				continue
+			}
+			seen[g] = struct{}{}
+			// a gram of a single sample doesn't help any other block
+			if f := freq[g]; f > 1 {
+				total += f
+			}
+		}
+		return total
+	}
+
+	h := &segmentHeap{}
+	for si, s := range samples {
+		for start := 0; start+dictGram <= len(s); start += dictStep {
+			seg := dictSegmentRef{sample: si, start: start, end: min(start+dictSegment, len(s))}
+			if seg.score = score(seg); seg.score > 0 {
+				*h = append(*h, seg)
+			}
+		}
+	}
+	heap.Init(h)
+	var picked [][]byte
+	size := 0
+	for h.Len() > 0 && size < maxSize {
+		seg := heap.Pop(h).(dictSegmentRef)
+		// the grams picked since it was scored don't count anymore, scores only go down
+		if seg.score = score(seg); seg.score == 0 {
+			continue
+		}
+		if h.Len() > 0 && seg.score < (*h)[0].score {
+			heap.Push(h, seg)
+			continue
+		}
+		b := samples[seg.sample][seg.start:seg.end]
+		b = b[:min(len(b), maxSize-size)]
+		picked = append(picked, b)
+		size += len(b)
+		for i := 0; i+dictGram <= len(b); i++ {
+			delete(freq, string(b[i:i+dictGram]))
+		}
+	}
+	raw := make([]byte, 0, size)
+	for i := len(picked) - 1; i >= 0; i-- {
+		raw = append(raw, picked[i]...)
+	}
+	d := NewDictionary(raw)
+	d.Samples = len(samples)
+	return d
+}
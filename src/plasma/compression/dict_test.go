@@ -0,0 +1,76 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// jsonDocs are small documents that share most of their structure, like the values of a user table
+func jsonDocs(n, seed int) [][]byte {
+	docs := make([][]byte, n)
+	for i := range docs {
+		id := seed + i
+		docs[i] = []byte(fmt.Sprintf(`{"id":%d,"type":"user","name":"user-%d","email":"user-%d@example.com","active":%t,"roles":["reader","writer"],"created_at":"2024-01-%02dT10:00:00Z"}`,
+			id, id, id, id%2 == 0, id%28+1))
+	}
+	return docs
+}
+
+func TestDictionary_CompressesSmallDocsBetter(t *testing.T) {
+	d := TrainDictionary(jsonDocs(500, 0), 4096)
+	if len(d.Raw) == 0 || len(d.Raw) > 4096 || d.Samples != 500 {
+		t.Fatalf("unexpected dictionary of %d bytes from %d samples", len(d.Raw), d.Samples)
+	}
+	plain, withDict := 0, 0
+	// the docs of another table, not the samples
+	for _, doc := range jsonDocs(100, 10000) {
+		plain += len(EncodeBlock(Flate, nil, doc))
+		data := EncodeBlock(d.Compressor(), nil, doc)
+		withDict += len(data)
+		got, err := d.DecodeBlock(nil, data)
+		if err != nil || !bytes.Equal(got, doc) {
+			t.Fatalf("round trip failed %v", err)
+		}
+	}
+	if withDict*3 > plain*2 {
+		t.Fatalf("expected the dictionary to save a third, %d vs %d bytes", withDict, plain)
+	}
+}
+
+func TestDictionary_Blocks(t *testing.T) {
+	d := TrainDictionary(jsonDocs(100, 0), 2048)
+	doc := jsonDocs(1, 500)[0]
+	data := EncodeBlock(d.Compressor(), nil, doc)
+	if Codec(data[len(data)-1]) != CodecFlateDict {
+		t.Fatalf("expected codec %s got %s", CodecFlateDict, Codec(data[len(data)-1]))
+	}
+	if _, err := DecodeBlock(nil, data); !errors.Is(err, ErrMissingDictionary) {
+		t.Fatalf("expected ErrMissingDictionary got %v", err)
+	}
+	// the dictionary read back from the meta block decodes the blocks, and the other codecs too
+	reread := NewDictionary(bytes.Clone(d.Raw))
+	if got, err := reread.DecodeBlock(nil, data); err != nil || !bytes.Equal(got, doc) {
+		t.Fatalf("expected the reread dictionary to decode, %v", err)
+	}
+	if got, err := reread.DecodeBlock(nil, EncodeBlock(LZ, nil, doc)); err != nil || !bytes.Equal(got, doc) {
+		t.Fatalf("expected an lz block to decode, %v", err)
+	}
+	if reread.ID != d.ID || reread.Properties()["compression.dictionary.id"] != d.Properties()["compression.dictionary.id"] {
+		t.Fatalf("expected the same dictionary id")
+	}
+	if d.Properties()["compression.dictionary.size"] != fmt.Sprint(len(d.Raw)) {
+		t.Fatalf("unexpected properties %v", d.Properties())
+	}
+}
+
+func TestTrainDictionary_NothingShared(t *testing.T) {
+	if d := TrainDictionary(nil, 1024); len(d.Raw) != 0 {
+		t.Fatalf("expected an empty dictionary got %d bytes", len(d.Raw))
+	}
+	// a single sample shares nothing with other blocks
+	if d := TrainDictionary(jsonDocs(1, 0), 1024); len(d.Raw) != 0 {
+		t.Fatalf("expected an empty dictionary got %d bytes", len(d.Raw))
+	}
+}
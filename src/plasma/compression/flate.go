@@ -0,0 +1,115 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/utils"
+)
+
+// flateCompressor keeps its writers and readers in pools, a flate.Writer is about 1MB of state
+// and the bottom level table writer compresses every block with it.
+type flateCompressor struct {
+	codec   Codec
+	level   int
+	dict    []byte
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// appendWriter appends what's written to b, so a pooled flate.Writer can write to the caller's dst
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+type flateWriter struct {
+	w   *flate.Writer
+	out appendWriter
+}
+
+type flateReader struct {
+	r   io.ReadCloser
+	src bytes.Reader
+}
+
+func newFlateCompressor(level int) *flateCompressor {
+	return newFlateDictCompressor(level, nil)
+}
+
+// newFlateDictCompressor compresses against dict, a Reset keeps it so the pools can be shared.
+func newFlateDictCompressor(level int, dict []byte) *flateCompressor {
+	f := &flateCompressor{codec: CodecFlate, level: level, dict: dict}
+	if dict != nil {
+		f.codec = CodecFlateDict
+	}
+	f.writers.New = func() any {
+		fw := &flateWriter{}
+		w, err := flate.NewWriterDict(&fw.out, f.level, f.dict)
+		utils.Assert(err == nil, "invalid flate level")
+		fw.w = w
+		return fw
+	}
+	f.readers.New = func() any {
+		fr := &flateReader{}
+		fr.r = flate.NewReaderDict(&fr.src, f.dict)
+		return fr
+	}
+	return f
+}
+
+func (f *flateCompressor) Codec() Codec {
+	return f.codec
+}
+
+func (f *flateCompressor) Compress(dst, src []byte) []byte {
+	fw := f.writers.Get().(*flateWriter)
+	defer f.writers.Put(fw)
+	fw.out.b = dst
+	fw.w.Reset(&fw.out)
+	// writes to an appendWriter don't fail
+	fw.w.Write(src)
+	fw.w.Close()
+	dst = fw.out.b
+	fw.out.b = nil
+	return dst
+}
+
+func (f *flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	fr := f.readers.Get().(*flateReader)
+	defer f.readers.Put(fr)
+	fr.src.Reset(src)
+	if err := fr.r.(flate.Resetter).Reset(&fr.src, f.dict); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptBlock, err)
+	}
+	return readAll(dst, fr.r)
+}
+
+// readAll appends r to dst like io.ReadAll, but fails once more than maxRawBlock bytes came out,
+// a corrupt block can expand without bound.
+func readAll(dst []byte, r io.Reader) ([]byte, error) {
+	start := len(dst)
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := r.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if len(dst)-start > maxRawBlock {
+			return nil, fmt.Errorf("%w: more than %d raw bytes", ErrCorruptBlock, maxRawBlock)
+		}
+		if err == io.EOF {
+			return dst, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptBlock, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package compression
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The lz format is the uvarint raw length followed by literal and copy ops:
+// literal: tagLiteral, uvarint length, the bytes
+// copy:    tagCopy, uvarint length, 2 byte offset back into the output
+const (
+	tagLiteral = 0
+	tagCopy    = 1
+
+	lzMinMatch  = 4
+	lzMaxOffset = 1<<16 - 1
+	lzHashLog   = 14
+)
+
+type lzCompressor struct{}
+
+func (lzCompressor) Codec() Codec {
+	return CodecLZ
+}
+
+func (lzCompressor) Compress(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	// table holds the last position+1 of every hashed 4 bytes, 0 is empty
+	var table [1 << lzHashLog]int32
+	lit := 0
+	i := 0
+	for i+lzMinMatch <= len(src) {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := (v * 2654435761) >> (32 - lzHashLog)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > lzMaxOffset || binary.LittleEndian.Uint32(src[cand:]) != v {
+			i++
+			continue
+		}
+		n := lzMinMatch
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		dst = appendLiteral(dst, src[lit:i])
+		dst = append(dst, tagCopy)
+		dst = binary.AppendUvarint(dst, uint64(n))
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(i-cand))
+		i += n
+		lit = i
+	}
+	return appendLiteral(dst, src[lit:])
+}
+
+func appendLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	dst = append(dst, tagLiteral)
+	dst = binary.AppendUvarint(dst, uint64(len(lit)))
+	return append(dst, lit...)
+}
+
+func (lzCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	raw, k := binary.Uvarint(src)
+	if k <= 0 || raw > uint64(maxRawBlock) {
+		return nil, fmt.Errorf("%w: bad raw length", ErrCorruptBlock)
+	}
+	src = src[k:]
+	start := len(dst)
+	for len(src) > 0 {
+		tag := src[0]
+		n, k := binary.Uvarint(src[1:])
+		if k <= 0 || uint64(len(dst)-start)+n > raw {
+			return nil, fmt.Errorf("%w: bad op length", ErrCorruptBlock)
+		}
+		src = src[1+k:]
+		switch tag {
+		case tagLiteral:
+			if uint64(len(src)) < n {
+				return nil, fmt.Errorf("%w: short literal", ErrCorruptBlock)
+			}
+			dst = append(dst, src[:n]...)
+			src = src[n:]
+		case tagCopy:
+			if len(src) < 2 {
+				return nil, fmt.Errorf("%w: short copy", ErrCorruptBlock)
+			}
+			off := int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+			if off == 0 || off > len(dst)-start {
+				return nil, fmt.Errorf("%w: bad copy offset %d", ErrCorruptBlock, off)
+			}
+			from := len(dst) - off
+			if uint64(off) >= n {
+				dst = append(dst, dst[from:from+int(n)]...)
+				continue
+			}
+			// an overlapping copy repeats the last off bytes
+			for j := range int(n) {
+				dst = append(dst, dst[from+j])
+			}
+		default:
+			return nil, fmt.Errorf("%w: bad tag %d", ErrCorruptBlock, tag)
+		}
+	}
+	if uint64(len(dst)-start) != raw {
+		return nil, fmt.Errorf("%w: expected %d bytes got %d", ErrCorruptBlock, raw, len(dst)-start)
+	}
+	return dst, nil
+}
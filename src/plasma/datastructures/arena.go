@@ -0,0 +1,73 @@
+package datastructures
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+var ErrArenaFull = errors.New("arena is full")
+
+// Arena bump allocates out of one fixed size buffer and hands out offsets instead of pointers,
+// so everything in it is a single pointer free object the GC never scans. Nothing is freed
+// piecewise, the buffer goes away with the memtable that owns it.
+// Offset 0 is never handed out so it can stand for nil.
+type Arena struct {
+	buf []byte
+	n   atomic.Uint64
+}
+
+// arenaAlign keeps every allocation 4 byte aligned for the atomic uint32 fields of arena nodes
+const arenaAlign = 4
+
+func NewArena(size uint32) *Arena {
+	a := &Arena{buf: make([]byte, size)}
+	a.n.Store(arenaAlign)
+	return a
+}
+
+// Alloc returns the offset of n zeroed bytes, it is safe to call concurrently.
+func (a *Arena) Alloc(n uint32) (uint32, error) {
+	padded := (uint64(n) + arenaAlign - 1) &^ (arenaAlign - 1)
+	end := a.n.Add(padded)
+	if end > uint64(len(a.buf)) {
+		return 0, ErrArenaFull
+	}
+	return uint32(end - padded), nil
+}
+
+// Bytes returns the n bytes at off, its cap is n so appending to it never spills into a neighbour.
+func (a *Arena) Bytes(off, n uint32) []byte {
+	return a.buf[off : off+n : off+n]
+}
+
+func (a *Arena) uint32At(off uint32) *atomic.Uint32 {
+	return (*atomic.Uint32)(unsafe.Pointer(&a.buf[off]))
+}
+
+func (a *Arena) getUint32(off uint32) uint32 {
+	return binary.LittleEndian.Uint32(a.buf[off:])
+}
+
+func (a *Arena) putUint32(off, v uint32) {
+	binary.LittleEndian.PutUint32(a.buf[off:], v)
+}
+
+func (a *Arena) getUint64(off uint32) uint64 {
+	return binary.LittleEndian.Uint64(a.buf[off:])
+}
+
+func (a *Arena) putUint64(off uint32, v uint64) {
+	binary.LittleEndian.PutUint64(a.buf[off:], v)
+}
+
+// Size is the memory held by the arena, the whole buffer.
+func (a *Arena) Size() uint64 {
+	return uint64(len(a.buf))
+}
+
+// Allocated is the number of bytes handed out, including the alignment padding.
+func (a *Arena) Allocated() uint64 {
+	return min(a.n.Load(), uint64(len(a.buf)))
+}
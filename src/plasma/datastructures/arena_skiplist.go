@@ -0,0 +1,289 @@
+package datastructures
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
+	"github.com/cloudnoize/el_gokv/src/plasma/types"
+	"github.com/cloudnoize/el_gokv/src/plasma/utils"
+)
+
+var ErrVersionOrder = errors.New("version is not higher than the latest version of the key")
+
+const (
+	arenaMaxHeight = 20
+	// node layout, all offsets into the arena:
+	// latest version u32 | key len u32 | height u32 | levels [height]u32 | key
+	nodeLatest = 0
+	nodeKeyLen = 4
+	nodeHeight = 8
+	nodeLevels = 12
+	// version layout: older version u32 | value len u32 | version u64 | value
+	versionNext   = 0
+	versionValLen = 4
+	versionNum    = 8
+	versionValue  = 16
+)
+
+// ArenaSkipList is a memtable whose nodes, keys and versions all live in one Arena and point at
+// each other by offsets, so a Put makes no heap allocation and the GC has a single object to track.
+// Inserts and version pushes are CASes on arena words, nothing is locked, and every version is kept
+// until the whole arena is dropped after the flush.
+type ArenaSkipList struct {
+	arena  *Arena
+	head   uint32
+	cmp    types.Comparator
+	levels *probability.LevelGenerator
+	size   atomic.Uint64
+	// Put holds sealLock for reading, so nothing is put after Seal returned
+	sealLock sync.RWMutex
+	sealed   bool
+}
+
+// NewArenaSkipList takes the SkipList options for the comparator and the level source,
+// WithRetention is not supported since the versions are only freed with the arena.
+func NewArenaSkipList(arenaSize uint32, opts ...SkipListOption) *ArenaSkipList {
+	conf := SkipList{cmp: types.BytewiseComparator}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	utils.Assert(conf.retention == nil, "ArenaSkipList keeps every version, it has no retention")
+	if conf.levelSrc == nil {
+		conf.levelSrc = probability.NewSplitMix64(rand.Uint64())
+	}
+	a := NewArena(arenaSize)
+	head, err := a.Alloc(nodeLevels + arenaMaxHeight*4)
+	utils.Assert(err == nil, "arena is too small for the skiplist head")
+	a.putUint32(head+nodeHeight, arenaMaxHeight)
+	return &ArenaSkipList{
+		arena:  a,
+		head:   head,
+		cmp:    conf.cmp,
+		levels: probability.NewLevelGenerator(0.5, conf.levelSrc),
+	}
+}
+
+func (s *ArenaSkipList) Comparator() types.Comparator {
+	return s.cmp
+}
+
+func (s *ArenaSkipList) headNode() uint32 {
+	return s.head
+}
+
+func (s *ArenaSkipList) height() int {
+	return arenaMaxHeight
+}
+
+func (s *ArenaSkipList) nextNode(n uint32, lvl int) uint32 {
+	return s.next(n, lvl).Load()
+}
+
+func (s *ArenaSkipList) next(n uint32, lvl int) *atomic.Uint32 {
+	return s.arena.uint32At(n + nodeLevels + 4*uint32(lvl))
+}
+
+func (s *ArenaSkipList) latest(n uint32) *atomic.Uint32 {
+	return s.arena.uint32At(n + nodeLatest)
+}
+
+func (s *ArenaSkipList) nodeKey(n uint32) []byte {
+	height := s.arena.getUint32(n + nodeHeight)
+	return s.arena.Bytes(n+nodeLevels+4*height, s.arena.getUint32(n+nodeKeyLen))
+}
+
+func (s *ArenaSkipList) version(v uint32) types.VersionedValue {
+	return types.VersionedValue{
+		Value:   s.arena.Bytes(v+versionValue, s.arena.getUint32(v+versionValLen)),
+		Version: s.arena.getUint64(v + versionNum),
+	}
+}
+
+func (s *ArenaSkipList) newNode(key []byte, height uint32) (uint32, error) {
+	n, err := s.arena.Alloc(nodeLevels + 4*height + uint32(len(key)))
+	if err != nil {
+		return 0, err
+	}
+	s.arena.putUint32(n+nodeKeyLen, uint32(len(key)))
+	s.arena.putUint32(n+nodeHeight, height)
+	copy(s.arena.Bytes(n+nodeLevels+4*height, uint32(len(key))), key)
+	return n, nil
+}
+
+func (s *ArenaSkipList) newVersion(value []byte, version uint64) (uint32, error) {
+	v, err := s.arena.Alloc(versionValue + uint32(len(value)))
+	if err != nil {
+		return 0, err
+	}
+	s.arena.putUint32(v+versionValLen, uint32(len(value)))
+	s.arena.putUint64(v+versionNum, version)
+	copy(s.arena.Bytes(v+versionValue, uint32(len(value))), value)
+	return v, nil
+}
+
+// Put copies kv into the arena, the caller can reuse its buffers. It fails with ErrArenaFull once the
+// arena can't hold kv, the memtable should be flushed then, and with ErrVersionOrder for a version
+// that is not higher than the key's latest.
+func (s *ArenaSkipList) Put(kv *types.KV) error {
+	s.sealLock.RLock()
+	defer s.sealLock.RUnlock()
+	if s.sealed {
+		return ErrSealed
+	}
+	v, err := s.newVersion(kv.Value, kv.Version)
+	if err != nil {
+		return err
+	}
+	var prev, next [arenaMaxHeight]uint32
+	var nn, height uint32
+	for {
+		if found := s.findSplice(kv.Key, 0, &prev, &next); found != 0 {
+			return s.pushVersion(found, v)
+		}
+		if nn == 0 {
+			height = uint32(min(s.levels.Level()+1, arenaMaxHeight))
+			if nn, err = s.newNode(kv.Key, height); err != nil {
+				return err
+			}
+			s.latest(nn).Store(v)
+		}
+		// once linked in level 0 the node is visible, the upper levels are only shortcuts
+		s.next(nn, 0).Store(next[0])
+		if s.next(prev[0], 0).CompareAndSwap(next[0], nn) {
+			break
+		}
+		// someone inserted between the offsets, search again, it may even be the same key
+	}
+	s.size.Add(1)
+	for lvl := 1; lvl < int(height); lvl++ {
+		for {
+			s.next(nn, lvl).Store(next[lvl])
+			if s.next(prev[lvl], lvl).CompareAndSwap(next[lvl], nn) {
+				break
+			}
+			s.findSplice(kv.Key, lvl, &prev, &next)
+		}
+	}
+	return nil
+}
+
+// pushVersion makes v the latest version of n, v links to the versions it replaces.
+func (s *ArenaSkipList) pushVersion(n, v uint32) error {
+	version := s.arena.getUint64(v + versionNum)
+	for {
+		top := s.latest(n).Load()
+		if s.arena.getUint64(top+versionNum) >= version {
+			return ErrVersionOrder
+		}
+		s.arena.putUint32(v+versionNext, top)
+		if s.latest(n).CompareAndSwap(top, v) {
+			s.size.Add(1)
+			return nil
+		}
+	}
+}
+
+// findSplice is SkipList.findSplice over arena offsets, 0 is nil.
+func (s *ArenaSkipList) findSplice(key []byte, minLvl int, prev, next *[arenaMaxHeight]uint32) uint32 {
+	curr := s.head
+	for lvl := arenaMaxHeight - 1; lvl >= minLvl; lvl-- {
+		nxt := s.next(curr, lvl).Load()
+		for nxt != 0 {
+			res := s.cmp.Compare(key, s.nodeKey(nxt))
+			if res == 0 {
+				return nxt
+			}
+			if res < 0 {
+				break
+			}
+			curr = nxt
+			nxt = s.next(curr, lvl).Load()
+		}
+		prev[lvl] = curr
+		next[lvl] = nxt
+	}
+	return 0
+}
+
+func (s *ArenaSkipList) Get(key []byte) (types.VersionedValue, bool) {
+	var prev, next [arenaMaxHeight]uint32
+	if n := s.findSplice(key, 0, &prev, &next); n != 0 {
+		return s.version(s.latest(n).Load()), true
+	}
+	return types.VersionedValue{}, false
+}
+
+// Size is the number of versions put.
+func (s *ArenaSkipList) Size() uint64 {
+	return s.size.Load()
+}
+
+// MemoryUsage is the part of the arena in use, keys and values together with the nodes.
+func (s *ArenaSkipList) MemoryUsage() uint64 {
+	return s.arena.Allocated()
+}
+
+// Seal waits for in flight Puts and returns an iterator over every key.
+func (s *ArenaSkipList) Seal() types.KVIterator {
+	s.sealLock.Lock()
+	s.sealed = true
+	s.sealLock.Unlock()
+	return s.Iterator()
+}
+
+// ArenaIterator walks the keys in comparator order, like Iterator it is safe to use while keys
+// are inserted.
+type ArenaIterator struct {
+	s    *ArenaSkipList
+	curr uint32
+}
+
+func (s *ArenaSkipList) Iterator() *ArenaIterator {
+	return &ArenaIterator{s: s, curr: s.next(s.head, 0).Load()}
+}
+
+// Seek is like SkipList.Seek.
+func (s *ArenaSkipList) Seek(key []byte) *ArenaIterator {
+	return &ArenaIterator{s: s, curr: s.next(findLess(s, key), 0).Load()}
+}
+
+// SeekToLast is like SkipList.SeekToLast.
+func (s *ArenaSkipList) SeekToLast() *ArenaIterator {
+	return &ArenaIterator{s: s, curr: notHead(s, findLast(s))}
+}
+
+func (it *ArenaIterator) Next() {
+	if it.curr != 0 {
+		it.curr = it.s.next(it.curr, 0).Load()
+	}
+}
+
+// Prev moves to the previous key with a search from the head, like Iterator.Prev.
+func (it *ArenaIterator) Prev() {
+	if it.curr != 0 {
+		it.curr = notHead(it.s, findLess(it.s, it.s.nodeKey(it.curr)))
+	}
+}
+
+func (it *ArenaIterator) Dref() *types.KV {
+	if it.curr == 0 {
+		return nil
+	}
+	latest := it.s.version(it.s.latest(it.curr).Load())
+	return &types.KV{Key: it.s.nodeKey(it.curr), Value: latest.Value, Version: latest.Version}
+}
+
+// Versions returns every version of the current key, newest first.
+func (it *ArenaIterator) Versions() []types.VersionedValue {
+	if it.curr == 0 {
+		return nil
+	}
+	var versions []types.VersionedValue
+	for v := it.s.latest(it.curr).Load(); v != 0; v = it.s.arena.getUint32(v + versionNext) {
+		versions = append(versions, it.s.version(v))
+	}
+	return versions
+}
@@ -0,0 +1,233 @@
+package datastructures
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
+	"github.com/cloudnoize/el_gokv/src/plasma/types"
+)
+
+func TestArenaSkipList_PutAndGet(t *testing.T) {
+	s := NewArenaSkipList(1 << 16)
+	key := []byte("key")
+	val := []byte("val")
+	if err := s.Put(&types.KV{Key: key, Value: val, Version: 1}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// the caller reusing its buffers must not change what's stored
+	copy(key, "xxx")
+	copy(val, "yyy")
+	got, ok := s.Get([]byte("key"))
+	if !ok || !bytes.Equal(got.Value, []byte("val")) || got.Version != 1 {
+		t.Fatalf("expected val@1 got %s@%d", got.Value, got.Version)
+	}
+	if _, ok := s.Get([]byte("xxx")); ok {
+		t.Fatalf("expected xxx to not exist")
+	}
+	if s.Size() != 1 || s.MemoryUsage() <= 6 {
+		t.Fatalf("unexpected size %d memory %d", s.Size(), s.MemoryUsage())
+	}
+}
+
+func TestArenaSkipList_Versions(t *testing.T) {
+	s := NewArenaSkipList(1 << 16)
+	for v := uint64(1); v <= 3; v++ {
+		if err := s.Put(&types.KV{Key: []byte("key"), Value: []byte(fmt.Sprintf("val-%d", v)), Version: v}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if err := s.Put(&types.KV{Key: []byte("key"), Value: []byte("old"), Version: 2}); !errors.Is(err, ErrVersionOrder) {
+		t.Fatalf("expected ErrVersionOrder got %v", err)
+	}
+	if got, _ := s.Get([]byte("key")); got.Version != 3 {
+		t.Fatalf("expected version 3 got %d", got.Version)
+	}
+	versions := s.Seal().Versions()
+	if len(versions) != 3 || s.Size() != 3 {
+		t.Fatalf("expected 3 versions got %d, size %d", len(versions), s.Size())
+	}
+	for i, v := range versions {
+		val := []byte(fmt.Sprintf("val-%d", 3-i))
+		if v.Version != uint64(3-i) || !bytes.Equal(v.Value, val) {
+			t.Fatalf("expected %s@%d got %s@%d", val, 3-i, v.Value, v.Version)
+		}
+	}
+	if err := s.Put(&types.KV{Key: []byte("key"), Value: []byte("late"), Version: 4}); !errors.Is(err, ErrSealed) {
+		t.Fatalf("expected ErrSealed got %v", err)
+	}
+}
+
+func TestArenaSkipList_SeekAndReverse(t *testing.T) {
+	s := NewArenaSkipList(1 << 16)
+	for _, i := range []int{5, 1, 9, 3, 7} {
+		s.Put(&types.KV{Key: []byte(fmt.Sprintf("k%d", i)), Value: []byte("v"), Version: uint64(i)})
+	}
+	var keys []string
+	for it := s.Iterator(); it.Dref() != nil; it.Next() {
+		keys = append(keys, string(it.Dref().Key))
+	}
+	if fmt.Sprint(keys) != "[k1 k3 k5 k7 k9]" {
+		t.Fatalf("expected sorted keys got %v", keys)
+	}
+	if it := s.Seek([]byte("k4")); string(it.Dref().Key) != "k5" {
+		t.Fatalf("expected seek to k5 got %s", it.Dref().Key)
+	}
+	keys = keys[:0]
+	for it := s.SeekToLast(); it.Dref() != nil; it.Prev() {
+		keys = append(keys, string(it.Dref().Key))
+	}
+	if fmt.Sprint(keys) != "[k9 k7 k5 k3 k1]" {
+		t.Fatalf("expected reversed keys got %v", keys)
+	}
+}
+
+func TestArenaSkipList_SeekEmptyKey(t *testing.T) {
+	s := NewArenaSkipList(1 << 16)
+	s.Put(&types.KV{Key: []byte("b"), Value: []byte("b"), Version: 1})
+	s.Put(&types.KV{Key: []byte("a"), Value: []byte("a"), Version: 2})
+	if it := s.Seek(nil); it.Dref() == nil || string(it.Dref().Key) != "a" {
+		t.Fatalf("expected Seek(nil) to land on a got %v", it.Dref())
+	}
+
+	s.Put(&types.KV{Key: nil, Value: []byte("empty"), Version: 3})
+	if it := s.Seek(nil); it.Dref() == nil || len(it.Dref().Key) != 0 {
+		t.Fatalf("expected Seek(nil) to land on the empty key got %v", it.Dref())
+	}
+	var keys []string
+	for it := s.SeekToLast(); it.Dref() != nil && len(keys) < 10; it.Prev() {
+		keys = append(keys, string(it.Dref().Key))
+	}
+	if fmt.Sprintf("%q", keys) != `["b" "a" ""]` {
+		t.Fatalf("expected [b a \"\"] got %q", keys)
+	}
+}
+
+func TestArenaSkipList_Options(t *testing.T) {
+	s := NewArenaSkipList(1<<16, WithComparator(types.ReverseBytewiseComparator))
+	for i := range 5 {
+		s.Put(&types.KV{Key: []byte(fmt.Sprintf("k%d", i)), Value: []byte("v"), Version: uint64(i + 1)})
+	}
+	var keys []string
+	for it := s.Iterator(); it.Dref() != nil; it.Next() {
+		keys = append(keys, string(it.Dref().Key))
+	}
+	if fmt.Sprint(keys) != "[k4 k3 k2 k1 k0]" {
+		t.Fatalf("expected reverse order got %v", keys)
+	}
+	if s.Comparator().Name() != types.ReverseBytewiseComparator.Name() {
+		t.Fatalf("expected comparator %s got %s", types.ReverseBytewiseComparator.Name(), s.Comparator().Name())
+	}
+
+	// the same seed gives the same heights, so the same nodes on every level
+	levels := func() []int {
+		s := NewArenaSkipList(1<<16, WithLevelSource(probability.NewSplitMix64(7)))
+		for i := range 100 {
+			s.Put(&types.KV{Key: []byte(fmt.Sprintf("k%03d", i)), Value: []byte("v"), Version: uint64(i + 1)})
+		}
+		counts := make([]int, arenaMaxHeight)
+		for lvl := range counts {
+			for n := s.nextNode(s.head, lvl); n != 0; n = s.nextNode(n, lvl) {
+				counts[lvl]++
+			}
+		}
+		return counts
+	}
+	if a, b := levels(), levels(); fmt.Sprint(a) != fmt.Sprint(b) || a[1] == 0 {
+		t.Fatalf("expected the same levels for the same seed got %v and %v", a, b)
+	}
+}
+
+func TestArenaSkipList_Full(t *testing.T) {
+	s := NewArenaSkipList(1024)
+	var err error
+	puts := 0
+	for ; err == nil; puts++ {
+		err = s.Put(&types.KV{Key: []byte(fmt.Sprintf("key-%d", puts)), Value: []byte("value"), Version: uint64(puts + 1)})
+	}
+	if !errors.Is(err, ErrArenaFull) {
+		t.Fatalf("expected ErrArenaFull got %v", err)
+	}
+	if s.Size() != uint64(puts-1) {
+		t.Fatalf("expected %d keys got %d", puts-1, s.Size())
+	}
+	if _, ok := s.Get([]byte("key-0")); !ok {
+		t.Fatalf("expected the keys put before the arena filled up")
+	}
+}
+
+func TestArenaSkipList_ConcurrentPuts(t *testing.T) {
+	s := NewArenaSkipList(1 << 22)
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				key := binary.BigEndian.AppendUint32(nil, uint32(i*8+w))
+				if err := s.Put(&types.KV{Key: key, Value: key, Version: uint64(i + 1)}); err != nil {
+					t.Errorf("unexpected error %v", err)
+					return
+				}
+				// every writer also updates one shared key, concurrent versions may arrive out of order
+				if err := s.Put(&types.KV{Key: []byte("shared"), Value: key, Version: uint64(i*8 + w + 1)}); err != nil && !errors.Is(err, ErrVersionOrder) {
+					t.Errorf("unexpected error %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var prev []byte
+	count := 0
+	for it := s.Iterator(); it.Dref() != nil; it.Next() {
+		kv := it.Dref()
+		if prev != nil && bytes.Compare(prev, kv.Key) >= 0 {
+			t.Fatalf("keys out of order %x >= %x", prev, kv.Key)
+		}
+		prev = kv.Key
+		count++
+	}
+	if count != 8001 {
+		t.Fatalf("expected 8001 keys got %d", count)
+	}
+}
+
+// The memtable benchmarks put 64K keys into a fresh store, the arena one should allocate next to nothing.
+const benchKeys = 1 << 16
+
+func benchKVs() []*types.KV {
+	kvs := make([]*types.KV, benchKeys)
+	for i := range kvs {
+		key := []byte(fmt.Sprintf("key-%08d", i))
+		kvs[i] = &types.KV{Key: key, Value: key, Version: uint64(i + 1)}
+	}
+	return kvs
+}
+
+func BenchmarkMapNSkip_Put(b *testing.B) {
+	kvs := benchKVs()
+	b.ReportAllocs()
+	for b.Loop() {
+		m := NewMapNSkip(benchKeys)
+		for _, kv := range kvs {
+			m.Put(kv)
+		}
+		m.Seal()
+	}
+}
+
+func BenchmarkArenaSkipList_Put(b *testing.B) {
+	kvs := benchKVs()
+	b.ReportAllocs()
+	for b.Loop() {
+		s := NewArenaSkipList(16 << 20)
+		for _, kv := range kvs {
+			s.Put(kv)
+		}
+		s.Seal()
+	}
+}
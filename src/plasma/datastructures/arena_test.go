@@ -0,0 +1,73 @@
+package datastructures
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestArena_AllocDoesNotOverlap(t *testing.T) {
+	a := NewArena(64)
+	first, _ := a.Alloc(5)
+	second, _ := a.Alloc(6)
+	if first == 0 || second == 0 {
+		t.Fatalf("expected offset 0 to be reserved for nil")
+	}
+	copy(a.Bytes(first, 5), "first")
+	copy(a.Bytes(second, 6), "second")
+	// appending to an arena slice must not overwrite its neighbour
+	_ = append(a.Bytes(first, 5), []byte("XXXXXX")...)
+	if !bytes.Equal(a.Bytes(first, 5), []byte("first")) || !bytes.Equal(a.Bytes(second, 6), []byte("second")) {
+		t.Fatalf("expected first/second got %s/%s", a.Bytes(first, 5), a.Bytes(second, 6))
+	}
+	if second%arenaAlign != 0 {
+		t.Fatalf("expected aligned offsets got %d", second)
+	}
+	if a.Allocated() != 20 || a.Size() != 64 {
+		t.Fatalf("expected 20 of 64 bytes allocated got %d of %d", a.Allocated(), a.Size())
+	}
+}
+
+func TestArena_Full(t *testing.T) {
+	a := NewArena(32)
+	if _, err := a.Alloc(24); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := a.Alloc(8); !errors.Is(err, ErrArenaFull) {
+		t.Fatalf("expected ErrArenaFull got %v", err)
+	}
+	if a.Allocated() != 32 {
+		t.Fatalf("expected allocated to stop at the arena size got %d", a.Allocated())
+	}
+}
+
+func TestArena_ConcurrentAlloc(t *testing.T) {
+	a := NewArena(1 << 16)
+	var wg sync.WaitGroup
+	offs := make([][]uint32, 8)
+	for w := range offs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				off, err := a.Alloc(8)
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+					return
+				}
+				offs[w] = append(offs[w], off)
+			}
+		}()
+	}
+	wg.Wait()
+	seen := map[uint32]bool{}
+	for _, w := range offs {
+		for _, off := range w {
+			if seen[off] {
+				t.Fatalf("offset %d handed out twice", off)
+			}
+			seen[off] = true
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package datastructures
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
+)
+
+// BlockKey identifies a block of a table file, the file number is unique across the dbs sharing a cache.
+type BlockKey struct {
+	FileNum uint64
+	Offset  uint64
+}
+
+type BlockCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries uint64
+	// Used is the bytes of all cached blocks, Pinned the part of it that can't be evicted
+	Used   uint64
+	Pinned uint64
+}
+
+// BlockCache is an LRU of table blocks with a byte budget, split into shards by the hash of the key
+// like the TSMap buckets so lookups of different blocks rarely contend. It is safe for concurrent use
+// and can be shared by several dbs. Pinned blocks (index and filter blocks) count against the budget
+// but are never evicted, until they are unpinned.
+type BlockCache struct {
+	lru    *shardedLRU[BlockKey, []byte]
+	hasher probability.Hasher
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewBlockCache(budget uint64, numShards int) *BlockCache {
+	return &BlockCache{lru: newShardedLRU[BlockKey, []byte](budget, numShards), hasher: probability.DefaultHasher}
+}
+
+func (c *BlockCache) hash(key BlockKey) uint64 {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], key.FileNum)
+	binary.LittleEndian.PutUint64(b[8:], key.Offset)
+	return c.hasher.Hash(b[:])
+}
+
+// Get returns the cached block, the caller must not modify it.
+func (c *BlockCache) Get(key BlockKey) ([]byte, bool) {
+	block, ok := c.lru.get(c.hash(key), key)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return block, true
+}
+
+// Insert caches block under key, evicting the least recently used blocks over the budget.
+func (c *BlockCache) Insert(key BlockKey, block []byte) {
+	c.lru.insert(c.hash(key), key, block, uint64(len(block)), false, nil)
+}
+
+// InsertPinned caches block under key and keeps it until Unpin.
+func (c *BlockCache) InsertPinned(key BlockKey, block []byte) {
+	c.lru.insert(c.hash(key), key, block, uint64(len(block)), true, nil)
+}
+
+// Unpin makes a pinned block evictable again, e.g. when its table is closed.
+func (c *BlockCache) Unpin(key BlockKey) {
+	c.lru.unpin(c.hash(key), key)
+}
+
+// Erase drops key from the cache, pinned or not.
+func (c *BlockCache) Erase(key BlockKey) {
+	c.lru.erase(c.hash(key), key)
+}
+
+func (c *BlockCache) Stats() BlockCacheStats {
+	stats := BlockCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	stats.Entries, stats.Used, stats.Pinned = c.lru.usage()
+	return stats
+}
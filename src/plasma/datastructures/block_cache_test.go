@@ -0,0 +1,126 @@
+package datastructures
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestBlockCache_GetAndStats(t *testing.T) {
+	c := NewBlockCache(1024, 4)
+	key := BlockKey{FileNum: 1, Offset: 0}
+	c.Insert(key, []byte("block"))
+
+	if got, ok := c.Get(key); !ok || !bytes.Equal(got, []byte("block")) {
+		t.Fatalf("expected block got %s", got)
+	}
+	if _, ok := c.Get(BlockKey{FileNum: 2, Offset: 0}); ok {
+		t.Fatalf("expected a miss for another file")
+	}
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 || stats.Used != 5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBlockCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	// a single shard so the budget applies to all keys
+	c := NewBlockCache(30, 1)
+	block := bytes.Repeat([]byte("x"), 10)
+	for off := range uint64(3) {
+		c.Insert(BlockKey{FileNum: 1, Offset: off}, block)
+	}
+	// touch 0 so 1 is the least recently used
+	c.Get(BlockKey{FileNum: 1, Offset: 0})
+	c.Insert(BlockKey{FileNum: 1, Offset: 3}, block)
+
+	if _, ok := c.Get(BlockKey{FileNum: 1, Offset: 1}); ok {
+		t.Fatalf("expected offset 1 to be evicted")
+	}
+	for _, off := range []uint64{0, 2, 3} {
+		if _, ok := c.Get(BlockKey{FileNum: 1, Offset: off}); !ok {
+			t.Fatalf("expected offset %d to be cached", off)
+		}
+	}
+	if used := c.Stats().Used; used != 30 {
+		t.Fatalf("expected 30 bytes used got %d", used)
+	}
+}
+
+func TestBlockCache_Pinning(t *testing.T) {
+	c := NewBlockCache(20, 1)
+	index := BlockKey{FileNum: 1, Offset: 100}
+	c.InsertPinned(index, bytes.Repeat([]byte("i"), 15))
+	for off := range uint64(5) {
+		c.Insert(BlockKey{FileNum: 1, Offset: off}, bytes.Repeat([]byte("d"), 10))
+	}
+	if _, ok := c.Get(index); !ok {
+		t.Fatalf("expected the pinned block to survive eviction")
+	}
+	if stats := c.Stats(); stats.Pinned != 15 || stats.Entries != 1 {
+		t.Fatalf("expected only the pinned block to fit, got %+v", stats)
+	}
+
+	c.Unpin(index)
+	c.Insert(BlockKey{FileNum: 1, Offset: 0}, bytes.Repeat([]byte("d"), 10))
+	if _, ok := c.Get(index); ok {
+		t.Fatalf("expected the unpinned block to be evicted")
+	}
+	if stats := c.Stats(); stats.Pinned != 0 || stats.Used != 10 {
+		t.Fatalf("unexpected stats after unpin %+v", stats)
+	}
+}
+
+func TestBlockCache_ReplaceAndErase(t *testing.T) {
+	c := NewBlockCache(100, 2)
+	key := BlockKey{FileNum: 7, Offset: 4096}
+	c.Insert(key, []byte("old"))
+	c.Insert(key, []byte("newer"))
+	if got, _ := c.Get(key); !bytes.Equal(got, []byte("newer")) {
+		t.Fatalf("expected newer got %s", got)
+	}
+	c.Erase(key)
+	if _, ok := c.Get(key); ok {
+		t.Fatalf("expected the block to be erased")
+	}
+	if stats := c.Stats(); stats.Used != 0 || stats.Entries != 0 {
+		t.Fatalf("expected an empty cache got %+v", stats)
+	}
+}
+
+func TestBlockCache_ConcurrentAccess(t *testing.T) {
+	c := NewBlockCache(64*1024, 16)
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range 2000 {
+				key := BlockKey{FileNum: uint64(w), Offset: uint64(i % 300)}
+				if got, ok := c.Get(key); ok && !bytes.Equal(got, []byte(fmt.Sprintf("%d-%d", w, i%300))) {
+					t.Errorf("unexpected block %s for %v", got, key)
+				}
+				c.Insert(key, []byte(fmt.Sprintf("%d-%d", w, i%300)))
+			}
+		}(w)
+	}
+	wg.Wait()
+	if stats := c.Stats(); stats.Used > 64*1024 || stats.Hits == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func BenchmarkBlockCache_Get(b *testing.B) {
+	c := NewBlockCache(1<<20, 16)
+	for off := range uint64(1024) {
+		c.Insert(BlockKey{FileNum: 1, Offset: off}, make([]byte, 512))
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := uint64(0)
+		for pb.Next() {
+			c.Get(BlockKey{FileNum: 1, Offset: i % 1024})
+			i++
+		}
+	})
+}
@@ -1 +1,4 @@
 package datastructures
+
+//TODO the bloom filter should hash through a probability.Hasher, since it's persisted in the table files
+//it has to use a fixed seed stored with it and not probability.ProcessSeed.
@@ -0,0 +1,149 @@
+package datastructures
+
+import "sync"
+
+type lruEntry[K comparable, V any] struct {
+	key    K
+	value  V
+	charge uint64
+	pinned bool
+	// prev and next link the unpinned entries, most recently used after the shard's lru head
+	prev, next *lruEntry[K, V]
+}
+
+type lruShard[K comparable, V any] struct {
+	lock    sync.Mutex
+	entries map[K]*lruEntry[K, V]
+	lru     lruEntry[K, V]
+	budget  uint64
+	used    uint64
+	pinned  uint64
+}
+
+// shardedLRU is the LRU behind BlockCache and RowCache, split into shards by a hash the caller
+// computes so lookups of different keys rarely contend. Every entry has a charge against the
+// byte budget, pinned entries count against it but are never evicted.
+type shardedLRU[K comparable, V any] struct {
+	shards []lruShard[K, V]
+}
+
+func newShardedLRU[K comparable, V any](budget uint64, numShards int) *shardedLRU[K, V] {
+	numShards = max(numShards, 1)
+	c := &shardedLRU[K, V]{shards: make([]lruShard[K, V], numShards)}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.entries = make(map[K]*lruEntry[K, V])
+		s.budget = budget / uint64(numShards)
+		s.lru.prev = &s.lru
+		s.lru.next = &s.lru
+	}
+	return c
+}
+
+func (c *shardedLRU[K, V]) shard(h uint64) *lruShard[K, V] {
+	return &c.shards[h%uint64(len(c.shards))]
+}
+
+func (c *shardedLRU[K, V]) get(h uint64, key K) (V, bool) {
+	s := c.shard(h)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	if !e.pinned {
+		s.unlink(e)
+		s.pushFront(e)
+	}
+	return e.value, true
+}
+
+// insert adds or replaces key, unless keep is set and returns false for the cached value.
+func (c *shardedLRU[K, V]) insert(h uint64, key K, value V, charge uint64, pinned bool, keep func(old V) bool) {
+	s := c.shard(h)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.entries[key]; ok {
+		if keep != nil && !keep(old.value) {
+			return
+		}
+		s.remove(old)
+	}
+	e := &lruEntry[K, V]{key: key, value: value, charge: charge, pinned: pinned}
+	s.entries[key] = e
+	s.used += charge
+	if pinned {
+		s.pinned += charge
+	} else {
+		s.pushFront(e)
+	}
+	s.evict()
+}
+
+func (c *shardedLRU[K, V]) unpin(h uint64, key K) {
+	s := c.shard(h)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	if !ok || !e.pinned {
+		return
+	}
+	e.pinned = false
+	s.pinned -= e.charge
+	s.pushFront(e)
+	s.evict()
+}
+
+func (c *shardedLRU[K, V]) erase(h uint64, key K) {
+	s := c.shard(h)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+}
+
+// usage returns the number of entries, their charge and the pinned part of it.
+func (c *shardedLRU[K, V]) usage() (entries, used, pinned uint64) {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.lock.Lock()
+		entries += uint64(len(s.entries))
+		used += s.used
+		pinned += s.pinned
+		s.lock.Unlock()
+	}
+	return entries, used, pinned
+}
+
+// evict drops least recently used entries until the shard fits its budget or only pinned ones are left.
+func (s *lruShard[K, V]) evict() {
+	for s.used > s.budget && s.lru.prev != &s.lru {
+		s.remove(s.lru.prev)
+	}
+}
+
+func (s *lruShard[K, V]) remove(e *lruEntry[K, V]) {
+	delete(s.entries, e.key)
+	s.used -= e.charge
+	if e.pinned {
+		s.pinned -= e.charge
+	} else {
+		s.unlink(e)
+	}
+}
+
+func (s *lruShard[K, V]) pushFront(e *lruEntry[K, V]) {
+	e.prev = &s.lru
+	e.next = s.lru.next
+	s.lru.next.prev = e
+	s.lru.next = e
+}
+
+func (s *lruShard[K, V]) unlink(e *lruEntry[K, V]) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev, e.next = nil, nil
+}
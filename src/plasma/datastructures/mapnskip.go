@@ -1,48 +1,94 @@
 package datastructures
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/cloudnoize/el_gokv/src/plasma/types"
 )
 
+var ErrSealed = errors.New("mapnskip is sealed")
+
+// MapNSkip keeps the kv it gets, the caller must not reuse its buffers.
 type MapNSkip struct {
 	tsmap  *TSMap[*types.KV]
 	sl     *SkipList
 	slchan chan *types.KV
-	size   uint64
-	bytes  uint64
+	// Put holds sealLock for reading while sending, so Seal never closes slchan under a sender
+	sealLock   sync.RWMutex
+	sealed     bool
+	workerDone chan struct{}
+	size       uint64
+	bytes      uint64
 }
 
-func NewMapNSkip(cap uint64) *MapNSkip {
-	ms := &MapNSkip{tsmap: NewTSMap[*types.KV](cap), sl: NewSkipList(cap, 0.5), slchan: make(chan *types.KV, cap)}
+func NewMapNSkip(cap uint64, opts ...SkipListOption) *MapNSkip {
+	ms := &MapNSkip{tsmap: NewTSMap[*types.KV](cap), sl: NewSkipList(cap, 0.5, opts...), slchan: make(chan *types.KV, cap), workerDone: make(chan struct{})}
 	go ms.SlPutWorker()
 	return ms
 }
 
 func (m *MapNSkip) SlPutWorker() {
+	defer close(m.workerDone)
 	for kv := range m.slchan {
 		if kv == nil {
 			continue
 		}
-		key, _, ver := kv.Unpack()
-		m.sl.Put(key, nil, ver)
+		size, bytes := m.sl.Size(), m.sl.SizeBytes()
+		m.sl.PutKV(kv)
+		// retention may have pruned older versions of the key
+		atomic.AddUint64(&m.size, -(size + 1 - m.sl.Size()))
+		atomic.AddUint64(&m.bytes, -(bytes + uint64(len(kv.Key)+len(kv.Value)) - m.sl.SizeBytes()))
 	}
 }
 
-func (m *MapNSkip) Put(kv *types.KV) {
-	m.tsmap.Put(kv.Key, kv)
+func (m *MapNSkip) Put(kv *types.KV) error {
+	m.sealLock.RLock()
+	defer m.sealLock.RUnlock()
+	if m.sealed {
+		return ErrSealed
+	}
+	// the skiplist keeps the versions, the map only serves the latest
+	m.tsmap.PutLatest(kv.Key, kv, kv.Version)
+	// counted before the send, so the worker never subtracts what wasn't added yet
+	atomic.AddUint64(&m.size, 1)
+	atomic.AddUint64(&m.bytes, uint64(len(kv.Key)+len(kv.Value)))
 	m.slchan <- kv
-	m.size++
-	m.bytes += uint64(len(kv.Key) + len(kv.Value))
+	return nil
 }
 
+// Seal waits for in flight Puts, closes slchan and waits for the worker to drain it,
+// so the returned iterator sees every key. It can be called more than once.
+func (m *MapNSkip) Seal() types.KVIterator {
+	m.sealLock.Lock()
+	if !m.sealed {
+		m.sealed = true
+		close(m.slchan)
+	}
+	m.sealLock.Unlock()
+	<-m.workerDone
+	m.sl.PruneVersions()
+	// every put is in the skiplist now, its counters are exact
+	atomic.StoreUint64(&m.size, m.sl.Size())
+	atomic.StoreUint64(&m.bytes, m.sl.SizeBytes())
+	return m.sl.Iterator()
+}
+
+// Size is the number of retained versions, it goes down as the retention policy prunes them.
 func (m *MapNSkip) Size() uint64 {
-	return m.size
+	return atomic.LoadUint64(&m.size)
 }
 
 func (m *MapNSkip) SizeBytes() uint64 {
-	return m.bytes
+	return atomic.LoadUint64(&m.bytes)
+}
+
+// MemoryUsage is the memory the MapNSkip holds, the keys and values plus the map and skiplist nodes.
+func (m *MapNSkip) MemoryUsage() uint64 {
+	return m.SizeBytes() + m.tsmap.MemoryUsage() + m.sl.MemoryUsage() + m.tsmap.Size()*uint64(unsafe.Sizeof(types.KV{}))
 }
 
 func (m *MapNSkip) Get(key []byte) (types.VersionedValue, bool) {
@@ -2,8 +2,11 @@ package datastructures
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/cloudnoize/el_gokv/src/plasma/types"
@@ -19,7 +22,7 @@ func TestMApNSkipPutAndGet(t *testing.T) {
 	value := []byte("world")
 	version := 1
 
-	m.Put(&types.KV{key, value, uint64(version)})
+	m.Put(&types.KV{Key: key, Value: value, Version: uint64(version)})
 	got, ok := m.Get(key)
 	if !ok {
 		t.Fatalf("expected key to be found")
@@ -47,7 +50,7 @@ func TestMapNKip_ConcurrentAccess(t *testing.T) {
 			defer wg.Done()
 			key := []byte(fmt.Sprintf("key-%d", i))
 			val := []byte(fmt.Sprintf("val-%d", i))
-			m.Put(&types.KV{key, val, uint64(i)})
+			m.Put(&types.KV{Key: key, Value: val, Version: uint64(i)})
 			got, ok := m.Get(key)
 			if !ok {
 				t.Errorf("Not ok")
@@ -63,3 +66,130 @@ func TestMapNKip_ConcurrentAccess(t *testing.T) {
 		t.Fatalf("expected map to have elements, got %d", m.Size())
 	}
 }
+
+func TestMapNSkip_SealUnderConcurrentPuts(t *testing.T) {
+	m := NewMapNSkip(1024)
+	var wg sync.WaitGroup
+	numOps := 20000
+
+	for w := range 16 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < numOps; i += 16 {
+				key := []byte(fmt.Sprintf("key-%05d", i))
+				val := []byte(fmt.Sprintf("val-%05d", i))
+				if err := m.Put(&types.KV{Key: key, Value: val, Version: uint64(i)}); err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	itr := m.Seal()
+	idx := 0
+	for ; itr.Dref() != nil; itr.Next() {
+		kv := itr.Dref()
+		key := []byte(fmt.Sprintf("key-%05d", idx))
+		val := []byte(fmt.Sprintf("val-%05d", idx))
+		if !bytes.Equal(kv.Key, key) || !bytes.Equal(kv.Value, val) || kv.Version != uint64(idx) {
+			t.Fatalf("expected %s=%s@%d got %s=%s@%d", key, val, idx, kv.Key, kv.Value, kv.Version)
+		}
+		idx++
+	}
+	if idx != numOps {
+		t.Fatalf("expected %d keys after seal got %d", numOps, idx)
+	}
+
+	if err := m.Put(&types.KV{Key: []byte("late"), Value: []byte("late"), Version: uint64(numOps)}); !errors.Is(err, ErrSealed) {
+		t.Fatalf("expected ErrSealed got %v", err)
+	}
+	// sealing again is a no op
+	if m.Seal().Dref() == nil {
+		t.Fatalf("expected second seal to iterate the same keys")
+	}
+}
+
+func TestMapNSkip_SealWhilePutting(t *testing.T) {
+	m := NewMapNSkip(16)
+	var wg sync.WaitGroup
+	var acked atomic.Uint64
+
+	for w := range 8 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", w, i))
+				if err := m.Put(&types.KV{Key: key, Value: key, Version: uint64(i)}); err != nil {
+					return
+				}
+				acked.Add(1)
+			}
+		}(w)
+	}
+	for acked.Load() < 1000 {
+		runtime.Gosched()
+	}
+	itr := m.Seal()
+	wg.Wait()
+
+	count := uint64(0)
+	for ; itr.Dref() != nil; itr.Next() {
+		count++
+	}
+	if count != acked.Load() || count != m.Size() {
+		t.Fatalf("expected every acked put (%d) in the iterator, got %d, size %d", acked.Load(), count, m.Size())
+	}
+}
+
+func TestMapNSkip_SealExposesVersions(t *testing.T) {
+	m := NewMapNSkip(1024)
+	for v := uint64(1); v <= 3; v++ {
+		m.Put(&types.KV{Key: []byte("key"), Value: []byte(fmt.Sprintf("val-%d", v)), Version: v})
+	}
+	itr := m.Seal()
+	versions := itr.Versions()
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions got %d", len(versions))
+	}
+	for i, v := range versions {
+		val := []byte(fmt.Sprintf("val-%d", 3-i))
+		if v.Version != uint64(3-i) || !bytes.Equal(v.Value, val) {
+			t.Fatalf("expected %s@%d got %s@%d", val, 3-i, v.Value, v.Version)
+		}
+	}
+}
+
+func TestMapNSkip_RetentionShrinksCounters(t *testing.T) {
+	var snapshot atomic.Uint64
+	put := func(m *MapNSkip) {
+		for v := uint64(1); v <= 10; v++ {
+			for _, key := range []string{"a", "b"} {
+				m.Put(&types.KV{Key: []byte(key), Value: []byte("value"), Version: v})
+			}
+		}
+	}
+	all := NewMapNSkip(1024)
+	put(all)
+	m := NewMapNSkip(1024, WithRetention(RetentionPolicy{OldestSnapshot: snapshot.Load}))
+	put(m)
+	// a snapshot at 0 keeps every version, the map only keeps the latest
+	if m.Size() != 20 || m.tsmap.Size() != 2 {
+		t.Fatalf("expected 20 versions and 2 map entries got %d and %d", m.Size(), m.tsmap.Size())
+	}
+	// the snapshot moved on without the keys being updated, Seal prunes what no one reads
+	snapshot.Store(8)
+	itr := m.Seal()
+	all.Seal()
+	if m.Size() != 6 || m.SizeBytes() != 6*uint64(len("a")+len("value")) {
+		t.Fatalf("expected 6 versions of 6 bytes got %d, %d bytes", m.Size(), m.SizeBytes())
+	}
+	if m.MemoryUsage() >= all.MemoryUsage() {
+		t.Fatalf("expected pruning to use less than %d got %d", all.MemoryUsage(), m.MemoryUsage())
+	}
+	if versions := itr.Versions(); len(versions) != 3 || versions[2].Version != 8 {
+		t.Fatalf("expected versions 10..8 got %v", versions)
+	}
+}
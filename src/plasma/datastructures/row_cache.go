@@ -0,0 +1,76 @@
+package datastructures
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
+	"github.com/cloudnoize/el_gokv/src/plasma/types"
+)
+
+// rowOverhead is charged for every row on top of its key and value, the entry and the map slot
+var rowOverhead = uint64(unsafe.Sizeof(lruEntry[string, types.VersionedValue]{}))
+
+type RowCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Bypasses are reads at a snapshot older than the cached version, they have to go to the tables
+	Bypasses uint64
+	Entries  uint64
+	Used     uint64
+}
+
+// RowCache keeps the latest VersionedValue of hot user keys in front of the tables, with a byte budget.
+// A Put or Delete of a key must Invalidate it, and only values read at the latest version may be inserted,
+// a snapshot read older than the cached version bypasses the cache. It is safe for concurrent use.
+type RowCache struct {
+	lru      *shardedLRU[string, types.VersionedValue]
+	hasher   probability.Hasher
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	bypasses atomic.Uint64
+}
+
+func NewRowCache(budget uint64, numShards int) *RowCache {
+	return &RowCache{lru: newShardedLRU[string, types.VersionedValue](budget, numShards), hasher: probability.DefaultHasher}
+}
+
+// lookupKey views key as a string without copying, only for lookups, the cache never keeps it.
+func lookupKey(key []byte) string {
+	return unsafe.String(unsafe.SliceData(key), len(key))
+}
+
+// Get returns the cached value of key as a reader at snapshot sees it. A value newer than snapshot
+// may hide the version the snapshot reads, so it's a bypass and the caller reads the tables.
+func (c *RowCache) Get(key []byte, snapshot uint64) (types.VersionedValue, bool) {
+	vv, ok := c.lru.get(c.hasher.Hash(key), lookupKey(key))
+	if !ok {
+		c.misses.Add(1)
+		return types.VersionedValue{}, false
+	}
+	if vv.Version > snapshot {
+		c.bypasses.Add(1)
+		return types.VersionedValue{}, false
+	}
+	c.hits.Add(1)
+	return vv, true
+}
+
+// Insert caches vv as the value of key, a cached newer version is kept. The caller must not modify vv.Value.
+func (c *RowCache) Insert(key []byte, vv types.VersionedValue) {
+	charge := uint64(len(key)+len(vv.Value)) + rowOverhead
+	c.lru.insert(c.hasher.Hash(key), string(key), vv, charge, false, func(old types.VersionedValue) bool {
+		return old.Version < vv.Version
+	})
+}
+
+// Invalidate drops key, called on every Put or Delete of it.
+func (c *RowCache) Invalidate(key []byte) {
+	c.lru.erase(c.hasher.Hash(key), lookupKey(key))
+}
+
+func (c *RowCache) Stats() RowCacheStats {
+	stats := RowCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Bypasses: c.bypasses.Load()}
+	stats.Entries, stats.Used, _ = c.lru.usage()
+	return stats
+}
@@ -0,0 +1,93 @@
+package datastructures
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/types"
+)
+
+func TestRowCache_GetAndInvalidate(t *testing.T) {
+	c := NewRowCache(1<<20, 4)
+	key := []byte("user-1")
+	c.Insert(key, types.VersionedValue{Value: []byte("v5"), Version: 5})
+
+	if got, ok := c.Get([]byte("user-1"), 10); !ok || !bytes.Equal(got.Value, []byte("v5")) || got.Version != 5 {
+		t.Fatalf("expected v5@5 got %s@%d", got.Value, got.Version)
+	}
+	// the caller reusing its key buffer must not change what's cached
+	copy(key, "user-2")
+	if _, ok := c.Get([]byte("user-1"), 10); !ok {
+		t.Fatalf("expected user-1 to still be cached")
+	}
+	c.Invalidate([]byte("user-1"))
+	if _, ok := c.Get([]byte("user-1"), 10); ok {
+		t.Fatalf("expected user-1 to be invalidated")
+	}
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 0 || stats.Used != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRowCache_SnapshotBypass(t *testing.T) {
+	c := NewRowCache(1<<20, 1)
+	c.Insert([]byte("key"), types.VersionedValue{Value: []byte("v7"), Version: 7})
+	// a snapshot at 5 may read an older version than the cached one
+	if _, ok := c.Get([]byte("key"), 5); ok {
+		t.Fatalf("expected a read at an older snapshot to bypass the cache")
+	}
+	if got, ok := c.Get([]byte("key"), 7); !ok || got.Version != 7 {
+		t.Fatalf("expected version 7 at snapshot 7 got %d", got.Version)
+	}
+	// a late insert of an older version doesn't replace the newer one
+	c.Insert([]byte("key"), types.VersionedValue{Value: []byte("v3"), Version: 3})
+	if got, _ := c.Get([]byte("key"), 10); got.Version != 7 {
+		t.Fatalf("expected version 7 to be kept got %d", got.Version)
+	}
+	if stats := c.Stats(); stats.Bypasses != 1 || stats.Hits != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRowCache_ByteBudget(t *testing.T) {
+	value := bytes.Repeat([]byte("v"), 100)
+	charge := uint64(len("key-0")+len(value)) + rowOverhead
+	c := NewRowCache(3*charge, 1)
+	for i := range 4 {
+		c.Insert([]byte(fmt.Sprintf("key-%d", i)), types.VersionedValue{Value: value, Version: uint64(i + 1)})
+	}
+	if _, ok := c.Get([]byte("key-0"), 10); ok {
+		t.Fatalf("expected the least recently used row to be evicted")
+	}
+	if stats := c.Stats(); stats.Entries != 3 || stats.Used != 3*charge {
+		t.Fatalf("expected 3 rows of %d bytes got %+v", charge, stats)
+	}
+}
+
+func TestRowCache_ConcurrentAccess(t *testing.T) {
+	c := NewRowCache(1<<16, 8)
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 2000 {
+				key := []byte(fmt.Sprintf("key-%d", i%200))
+				if got, ok := c.Get(key, uint64(i)); ok && got.Version > uint64(i) {
+					t.Errorf("got version %d above snapshot %d", got.Version, i)
+				}
+				if i%10 == w {
+					c.Invalidate(key)
+				}
+				c.Insert(key, types.VersionedValue{Value: key, Version: uint64(i)})
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := c.Stats(); stats.Used > 1<<16 {
+		t.Fatalf("expected the budget to hold got %+v", stats)
+	}
+}
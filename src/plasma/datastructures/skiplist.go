@@ -1,8 +1,12 @@
 package datastructures
 
 import (
-	"bytes"
 	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/cloudnoize/el_gokv/src/plasma/probability"
 	"github.com/cloudnoize/el_gokv/src/plasma/types"
@@ -10,15 +14,100 @@ import (
 )
 
 type node struct {
-	levels    []*node
-	key       []byte
-	verValues Stack[types.VersionedValue]
+	levels []atomic.Pointer[node]
+	key    []byte
+	// mu serializes updates of an existing key, readers only load latest
+	mu        sync.Mutex
+	verValues Stack[version]
+	latest    atomic.Pointer[types.VersionedValue]
 }
 
+// version is a stacked value with the time it was put, for RetentionPolicy.KeepFor
+type version struct {
+	types.VersionedValue
+	at int64
+}
+
+var (
+	nodeOverhead    = uint64(unsafe.Sizeof(node{}))
+	levelOverhead   = uint64(unsafe.Sizeof(atomic.Pointer[node]{}))
+	versionOverhead = uint64(unsafe.Sizeof(version{}))
+)
+
 func newNode(height uint16) *node {
 	return &node{
-		levels: make([]*node, height),
+		levels: make([]atomic.Pointer[node], height),
+	}
+}
+
+// push adds vv on top of the versions of n and prunes them by policy, returns the number and bytes of pruned versions.
+func (n *node) push(vv types.VersionedValue, policy *RetentionPolicy) (int, uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	top, err := n.verValues.Top()
+	utils.Assert(err != nil || top.Version < vv.Version, "Input version is not higher than current version")
+	v := version{VersionedValue: vv}
+	if policy != nil && policy.KeepFor > 0 {
+		v.at = time.Now().UnixNano()
+	}
+	n.verValues.Push(v)
+	n.latest.Store(&vv)
+	return n.prune(policy)
+}
+
+// prune drops the bottom versions no rule of the policy keeps, must be called with mu held.
+// Returns the number of dropped versions and their bytes, the key is counted for each of them.
+func (n *node) prune(policy *RetentionPolicy) (int, uint64) {
+	if policy == nil || n.verValues.Size() == 1 {
+		return 0, 0
+	}
+	keep := policy.keep(&n.verValues)
+	drop := n.verValues.Size() - keep
+	bytes := uint64(0)
+	for i := keep; i < n.verValues.Size(); i++ {
+		v, _ := n.verValues.Peek(i)
+		bytes += uint64(len(n.key) + len(v.Value))
 	}
+	err := n.verValues.DropBottom(drop)
+	utils.Assert(err == nil, "Retention dropped more versions than there are")
+	return drop, bytes
+}
+
+// RetentionPolicy bounds the versions the skiplist keeps per key. Each rule keeps a number of the
+// newest versions and a version is kept if any set rule keeps it, so a snapshot never loses
+// what it reads. The latest version is always kept, so an empty policy keeps only the latest.
+type RetentionPolicy struct {
+	// KeepLast keeps the last KeepLast versions
+	KeepLast int
+	// KeepFor keeps the versions put in the last KeepFor
+	KeepFor time.Duration
+	// OldestSnapshot returns the version of the oldest live snapshot, it keeps every version
+	// above it and the latest one at or below it
+	OldestSnapshot func() uint64
+}
+
+func (p *RetentionPolicy) keep(versions *Stack[version]) int {
+	keep := max(p.KeepLast, 1)
+	var since int64
+	if p.KeepFor > 0 {
+		since = time.Now().Add(-p.KeepFor).UnixNano()
+	}
+	var snapshot uint64
+	if p.OldestSnapshot != nil {
+		snapshot = p.OldestSnapshot()
+	}
+	// the stack is oldest to newest, walk down from the top until no rule wants the version
+	for i := keep; i < versions.Size(); i++ {
+		v, _ := versions.Peek(i)
+		newer, _ := versions.Peek(i - 1)
+		keptByTime := p.KeepFor > 0 && v.at >= since
+		// if the next newer version is at or below the snapshot, it's the one the snapshot reads
+		keptBySnapshot := p.OldestSnapshot != nil && newer.Version > snapshot
+		if !keptByTime && !keptBySnapshot {
+			return i
+		}
+	}
+	return versions.Size()
 }
 
 // TODO I think that we can add a hashmap of from key_version -> value
@@ -31,113 +120,259 @@ type SkipList struct {
 	estimatedCap uint64
 	size         uint64
 	UpdatesSize  uint64
-	p            float64
+	// memory is what the nodes take, the keys and values are owned by the caller
+	memory uint64
+	// bytes is the keys and values of the retained versions, the key is counted for every version
+	bytes     uint64
+	p         float64
+	cmp       types.Comparator
+	retention *RetentionPolicy
+	levelSrc  probability.Source
+	levels    *probability.LevelGenerator
+}
+
+type SkipListOption func(*SkipList)
+
+// WithComparator orders the keys by cmp instead of bytes.Compare.
+func WithComparator(cmp types.Comparator) SkipListOption {
+	return func(s *SkipList) {
+		s.cmp = cmp
+	}
+}
+
+// WithRetention prunes old versions of a key by policy on every update of the key.
+func WithRetention(policy RetentionPolicy) SkipListOption {
+	return func(s *SkipList) {
+		s.retention = &policy
+	}
 }
 
-func NewSkipList(estimateCap uint64, p float64) *SkipList {
+// WithLevelSource draws the node heights from src, with a seeded source the shape of the list is reproducible.
+func WithLevelSource(src probability.Source) SkipListOption {
+	return func(s *SkipList) {
+		s.levelSrc = src
+	}
+}
+
+func NewSkipList(estimateCap uint64, p float64, opts ...SkipListOption) *SkipList {
 	utils.Assert(utils.IsPowerOf2(estimateCap), "Not a power of two")
 	mh := uint16(math.Log2(float64(estimateCap)))
-	return &SkipList{
+	s := &SkipList{
 		head:         newNode(mh),
 		maxHeight:    mh,
 		estimatedCap: estimateCap,
+		memory:       nodeOverhead + uint64(mh)*levelOverhead,
 		p:            p,
+		cmp:          types.BytewiseComparator,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	if s.levelSrc == nil {
+		s.levelSrc = probability.NewSplitMix64(rand.Uint64())
+	}
+	s.levels = probability.NewLevelGenerator(p, s.levelSrc)
+	return s
+}
+
+func (s *SkipList) Comparator() types.Comparator {
+	return s.cmp
+}
+
+func (s *SkipList) headNode() *node {
+	return s.head
+}
+
+func (s *SkipList) height() int {
+	return int(s.maxHeight)
+}
+
+func (s *SkipList) nextNode(n *node, lvl int) *node {
+	return n.levels[lvl].Load()
+}
+
+func (s *SkipList) nodeKey(n *node) []byte {
+	return n.key
+}
+
+func (s *SkipList) Size() uint64 {
+	return atomic.LoadUint64(&s.size) + atomic.LoadUint64(&s.UpdatesSize)
+}
+
+func (s *SkipList) MemoryUsage() uint64 {
+	return atomic.LoadUint64(&s.memory)
 }
 
-func (s SkipList) Size() uint64 {
-	return s.size + s.UpdatesSize
+// SizeBytes is the keys and values of the retained versions, it goes down as retention prunes them.
+func (s *SkipList) SizeBytes() uint64 {
+	return atomic.LoadUint64(&s.bytes)
 }
 
 func (s *SkipList) PutKV(kv *types.KV) {
 	s.Put(kv.Unpack())
 }
 
+// Put is safe to call concurrently, a new node is published with a CAS on level 0 and then
+// linked into the upper levels one CAS at a time. Only inserting a new key is lock free, an update to
+// an existing key takes that node's mutex to push the version, so writers of one hot key serialize.
+// Versions of the same key must arrive in increasing order, Put panics on a version that is not
+// higher than the key's latest, concurrent writers of one key must order their versions themselves.
 func (s *SkipList) Put(key, value []byte, version uint64) {
 	ptrsToNewNode := make([]*node, s.maxHeight)
 	ptrsFromNewNode := make([]*node, s.maxHeight)
+	var nn *node
+	var nodeHeight uint16
+	for {
+		if found := s.findSplice(key, 0, ptrsToNewNode, ptrsFromNewNode); found != nil {
+			// equals means it's an update to the value
+			pruned, prunedBytes := found.push(types.VersionedValue{Value: value, Version: version}, s.retention)
+			s.addVersions(1-pruned, uint64(len(key)+len(value))-prunedBytes)
+			return
+		}
+		if nn == nil {
+			nodeHeight = uint16(min(s.levels.Level()+1, int(s.maxHeight)))
+			nn = newNode(nodeHeight)
+			nn.key = key
+			nn.push(types.VersionedValue{Value: value, Version: version}, s.retention)
+		}
+		// once linked in level 0 the node is visible, the upper levels are only shortcuts
+		nn.levels[0].Store(ptrsFromNewNode[0])
+		if ptrsToNewNode[0].levels[0].CompareAndSwap(ptrsFromNewNode[0], nn) {
+			break
+		}
+		// someone inserted between the pointers, search again, it may even be the same key
+	}
+	atomic.AddUint64(&s.size, 1)
+	atomic.AddUint64(&s.memory, nodeOverhead+uint64(nodeHeight)*levelOverhead+versionOverhead)
+	atomic.AddUint64(&s.bytes, uint64(len(key)+len(value)))
+	for lvl := 1; lvl < int(nodeHeight); lvl++ {
+		for {
+			nn.levels[lvl].Store(ptrsFromNewNode[lvl])
+			if ptrsToNewNode[lvl].levels[lvl].CompareAndSwap(ptrsFromNewNode[lvl], nn) {
+				break
+			}
+			s.findSplice(key, lvl, ptrsToNewNode, ptrsFromNewNode)
+		}
+	}
+}
+
+// addVersions accounts for n more (or less when negative) updates, and for bytes more key and value
+// bytes, bytes wraps around to subtract like the counters of TSMap.
+func (s *SkipList) addVersions(n int, bytes uint64) {
+	atomic.AddUint64(&s.UpdatesSize, uint64(n))
+	atomic.AddUint64(&s.memory, uint64(n)*versionOverhead)
+	atomic.AddUint64(&s.bytes, bytes)
+}
+
+// PruneVersions applies the retention policy to every key, for when the oldest snapshot moved
+// on without the keys being updated, e.g. before a flush. Returns the number of pruned versions.
+func (s *SkipList) PruneVersions() int {
+	if s.retention == nil {
+		return 0
+	}
+	pruned := 0
+	prunedBytes := uint64(0)
+	for curr := s.head.levels[0].Load(); curr != nil; curr = curr.levels[0].Load() {
+		curr.mu.Lock()
+		n, bytes := curr.prune(s.retention)
+		curr.mu.Unlock()
+		pruned += n
+		prunedBytes += bytes
+	}
+	s.addVersions(-pruned, -prunedBytes)
+	return pruned
+}
+
+// findSplice fills, for every level down to minLvl, the last node smaller than key and the node after it.
+// if a node with the same key is met it's returned.
+func (s *SkipList) findSplice(key []byte, minLvl int, ptrsToNewNode, ptrsFromNewNode []*node) *node {
 	curr := s.head
-	for lvl := int(s.maxHeight) - 1; lvl >= 0; lvl-- {
-		next := curr.levels[lvl]
+	for lvl := int(s.maxHeight) - 1; lvl >= minLvl; lvl-- {
+		next := curr.levels[lvl].Load()
 		for {
 			if next == nil {
 				//end of current lvl, all keys are smaller
-				ptrsToNewNode[lvl] = curr
-				ptrsFromNewNode[lvl] = nil
 				break
 			}
-			res := bytes.Compare(key, next.key)
+			res := s.cmp.Compare(key, next.key)
 			if res == 0 {
-				// 0 is equals, and equals means it's an update to the value
-				utils.Assert(next.verValues.Top().Version < version, "Input version is not higher than current version")
-				next.verValues.Push(types.VersionedValue{Value: value, Version: version})
-				s.UpdatesSize++
-				return
+				return next
 			}
-			if res == -1 {
+			if res < 0 {
 				//next key is bigger, this is the insertion point
-				ptrsToNewNode[lvl] = curr
-				ptrsFromNewNode[lvl] = next
 				break
 			}
 			//next key is smaller, keep going
 			curr = next
-			next = curr.levels[lvl]
+			next = curr.levels[lvl].Load()
 		}
+		ptrsToNewNode[lvl] = curr
+		ptrsFromNewNode[lvl] = next
 	}
-	defer func() { s.size++ }()
-	nodeHeight := uint16(math.Min(probability.Geometric(s.p)+1, float64(s.maxHeight)))
-	node := newNode(nodeHeight)
-	node.key = key
-	node.verValues.Push(types.VersionedValue{Value: value, Version: version})
-	// insert the new node, make each layer poit to it and from it
-	//TODO lock
-	for i := 0; i < int(nodeHeight); i++ {
-		ptrsToNewNode[i].levels[i] = node
-		node.levels[i] = ptrsFromNewNode[i]
-	}
+	return nil
 }
 
 // TODO also version
-func (s SkipList) Get(key []byte) (types.VersionedValue, bool, uint64) {
+func (s *SkipList) Get(key []byte) (types.VersionedValue, bool, uint64) {
 	steps := uint64(0)
 	curr := s.head
 	for lvl := int(s.maxHeight) - 1; lvl >= 0; lvl-- {
-		next := curr.levels[lvl]
+		next := curr.levels[lvl].Load()
 		steps++
 		for {
 			if next == nil {
 				//end of current lvl, all keys are smaller
 				break
 			}
-			res := bytes.Compare(key, next.key)
+			res := s.cmp.Compare(key, next.key)
 			if res == 0 {
-				return next.verValues.Top(), true, steps
+				return *next.latest.Load(), true, steps
 			}
-			if res == -1 {
+			if res < 0 {
 				// next key is bigger,need to go down a level
 				break
 			}
 			//next key is smaller, keep going
 			curr = next
-			next = curr.levels[lvl]
+			next = curr.levels[lvl].Load()
 			steps++
 		}
 	}
 	return types.VersionedValue{}, false, steps
 }
 
+// Iterator walks the keys in comparator order, it is safe to use while keys are inserted
+// and sees the ones inserted ahead of it.
 type Iterator struct {
+	s    *SkipList
 	curr *node
 }
 
 func (s *SkipList) Iterator() *Iterator {
-	return &Iterator{curr: s.head.levels[0]}
+	return &Iterator{s: s, curr: s.head.levels[0].Load()}
+}
+
+// Seek returns an iterator at the first key at or after key.
+func (s *SkipList) Seek(key []byte) *Iterator {
+	return &Iterator{s: s, curr: findLess(s, key).levels[0].Load()}
+}
+
+// SeekToLast returns an iterator at the last key, to walk the list backwards with Prev.
+func (s *SkipList) SeekToLast() *Iterator {
+	return &Iterator{s: s, curr: notHead(s, findLast(s))}
 }
 
 func (it *Iterator) Next() {
 	if it.curr != nil {
-		it.curr = it.curr.levels[0]
+		it.curr = it.curr.levels[0].Load()
+	}
+}
+
+// Prev moves to the previous key. There are no back pointers, so it's a search from the head,
+// O(log n) rather than O(1) like Next. Prev of the first key ends the iterator.
+func (it *Iterator) Prev() {
+	if it.curr != nil {
+		it.curr = notHead(it.s, findLess(it.s, it.curr.key))
 	}
 }
 
@@ -145,5 +380,36 @@ func (it *Iterator) Dref() *types.KV {
 	if it.curr == nil {
 		return nil
 	}
-	return &types.KV{Key: it.curr.key, Value: it.curr.verValues.Top().Value, Version: it.curr.verValues.Top().Version}
+	latest := it.curr.latest.Load()
+	return &types.KV{Key: it.curr.key, Value: latest.Value, Version: latest.Version}
+}
+
+// Versions returns every retained version of the current key, newest first.
+func (it *Iterator) Versions() []types.VersionedValue {
+	if it.curr == nil {
+		return nil
+	}
+	it.curr.mu.Lock()
+	defer it.curr.mu.Unlock()
+	versions := make([]types.VersionedValue, 0, it.curr.verValues.Size())
+	for vit := it.curr.verValues.Iterator(); vit.Dref() != nil; vit.Next() {
+		versions = append(versions, vit.Dref().VersionedValue)
+	}
+	return versions
+}
+
+// AtSnapshot returns the version of the current key a reader at snapshot sees,
+// the newest one at or below snapshot.
+func (it *Iterator) AtSnapshot(snapshot uint64) (types.VersionedValue, bool) {
+	if it.curr == nil {
+		return types.VersionedValue{}, false
+	}
+	it.curr.mu.Lock()
+	defer it.curr.mu.Unlock()
+	for vit := it.curr.verValues.Iterator(); vit.Dref() != nil; vit.Next() {
+		if v := vit.Dref(); v.Version <= snapshot {
+			return v.VersionedValue, true
+		}
+	}
+	return types.VersionedValue{}, false
 }
@@ -0,0 +1,52 @@
+package datastructures
+
+import "github.com/cloudnoize/el_gokv/src/plasma/types"
+
+// linkedLevels is what the searches need from a skiplist, SkipList links its nodes by pointers and
+// ArenaSkipList by arena offsets. The zero N ends a level.
+// findSplice is not shared, it's the search of every Put and Get and going through linkedLevels
+// makes ArenaSkipList.Put about 70% slower.
+type linkedLevels[N comparable] interface {
+	Comparator() types.Comparator
+	headNode() N
+	height() int
+	nextNode(n N, lvl int) N
+	nodeKey(n N) []byte
+}
+
+// findLess returns the last node with a key smaller than key, or head if there is none.
+func findLess[N comparable](l linkedLevels[N], key []byte) N {
+	var none N
+	cmp := l.Comparator()
+	curr := l.headNode()
+	for lvl := l.height() - 1; lvl >= 0; lvl-- {
+		for next := l.nextNode(curr, lvl); next != none; next = l.nextNode(curr, lvl) {
+			if cmp.Compare(l.nodeKey(next), key) >= 0 {
+				break
+			}
+			curr = next
+		}
+	}
+	return curr
+}
+
+// findLast returns the last node, or head if the list is empty.
+func findLast[N comparable](l linkedLevels[N]) N {
+	var none N
+	curr := l.headNode()
+	for lvl := l.height() - 1; lvl >= 0; lvl-- {
+		for next := l.nextNode(curr, lvl); next != none; next = l.nextNode(curr, lvl) {
+			curr = next
+		}
+	}
+	return curr
+}
+
+// notHead maps head to the zero N, for when a search found nothing before a key.
+func notHead[N comparable](l linkedLevels[N], n N) N {
+	var none N
+	if n == l.headNode() {
+		return none
+	}
+	return n
+}
@@ -2,11 +2,14 @@ package datastructures
 
 import (
 	"bytes"
-	"crypto/rand"
-	"math/big"
+	"fmt"
+	"math/rand/v2"
 	"sort"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
 	"github.com/cloudnoize/el_gokv/src/plasma/types"
 )
 
@@ -97,18 +100,21 @@ func TestSkipList_severalPutKVsAndGets(t *testing.T) {
 
 func TestSkipList_randomPutKV(t *testing.T) {
 	size := uint64(1024)
-	sl := NewSkipList(size, 0.5)
+	// seeded so the keys and the shape of the list, and so the step counts below, are the same on every run
+	sl := NewSkipList(size, 0.5, WithLevelSource(probability.NewSplitMix64(1)))
+	rnd := rand.New(rand.NewPCG(1, 2))
 
 	elems := make([][]byte, size)
 
 	// Lambda function
 	gen := func() []byte {
 		// Pick a random length between 1 and 20
-		nBig, _ := rand.Int(rand.Reader, big.NewInt(20))
-		n := int(nBig.Int64()) + 1
+		n := rnd.IntN(20) + 1
 
 		b := make([]byte, n)
-		_, _ = rand.Read(b) // fill slice with random bytes
+		for i := range b {
+			b[i] = byte(rnd.Uint32())
+		}
 		return b
 	}
 
@@ -173,3 +179,269 @@ func TestSkipList_randomPutKV(t *testing.T) {
 		}
 	}
 }
+
+func TestSkipList_reverseComparator(t *testing.T) {
+	sl := NewSkipList(1024, 0.5, WithComparator(types.ReverseBytewiseComparator))
+	elems := [][]byte{
+		[]byte("bbb"),
+		[]byte("eee"),
+		[]byte("aaa"),
+		[]byte("ccc"),
+	}
+	for i, e := range elems {
+		sl.PutKV(&types.KV{Key: e, Value: e, Version: uint64(i)})
+	}
+
+	sort.Slice(elems, func(i, j int) bool {
+		return bytes.Compare(elems[i], elems[j]) > 0
+	})
+	itr := sl.Iterator()
+	for _, e := range elems {
+		if itr.Dref() == nil || !bytes.Equal(itr.Dref().Key, e) {
+			t.Fatalf("Expected %s got %v", e, itr.Dref())
+		}
+		itr.Next()
+	}
+	if itr.Dref() != nil {
+		t.Fatalf("Expected end of list got %s", itr.Dref().Key)
+	}
+
+	for _, key := range elems {
+		if val, ok, _ := sl.Get(key); !ok || !bytes.Equal(key, val.Value) {
+			t.Fatalf("Expected %s got %s", key, val.Value)
+		}
+	}
+	if sl.Comparator().Name() != types.ReverseBytewiseComparator.Name() {
+		t.Fatalf("Expected comparator %s got %s", types.ReverseBytewiseComparator.Name(), sl.Comparator().Name())
+	}
+}
+
+func TestSkipList_concurrentPutAndGet(t *testing.T) {
+	const (
+		writers = 8
+		perW    = 2000
+	)
+	sl := NewSkipList(1<<14, 0.5)
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range perW {
+				key := []byte(fmt.Sprintf("key-%05d", i*writers+w))
+				sl.Put(key, key, 1)
+				// update a key this writer owns so versions stay ordered per key
+				sl.Put(key, key, 2)
+				if v, ok, _ := sl.Get(key); !ok || !bytes.Equal(v.Value, key) {
+					t.Errorf("Expected %s got %s", key, v.Value)
+				}
+			}
+		}(w)
+	}
+	// readers run alongside the writers
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perW {
+				sl.Get([]byte(fmt.Sprintf("key-%05d", i)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sl.Size() != 2*writers*perW {
+		t.Fatalf("Expected %d got %d", 2*writers*perW, sl.Size())
+	}
+	itr := sl.Iterator()
+	count := 0
+	var prev []byte
+	for ; itr.Dref() != nil; itr.Next() {
+		kv := itr.Dref()
+		if prev != nil && bytes.Compare(prev, kv.Key) >= 0 {
+			t.Fatalf("Expected %s to be after %s", kv.Key, prev)
+		}
+		if kv.Version != 2 {
+			t.Fatalf("Expected version 2 for %s got %d", kv.Key, kv.Version)
+		}
+		prev = kv.Key
+		count++
+	}
+	if count != writers*perW {
+		t.Fatalf("Expected %d keys got %d", writers*perW, count)
+	}
+}
+
+func TestSkipList_retentionKeepLast(t *testing.T) {
+	sl := NewSkipList(1024, 0.5, WithRetention(RetentionPolicy{KeepLast: 3}))
+	key := []byte("hot")
+	for v := range uint64(10) {
+		sl.Put(key, key, v)
+	}
+	sl.Put([]byte("cold"), nil, 10)
+	// 2 keys, 2 more versions of hot
+	if sl.Size() != 4 {
+		t.Fatalf("Expected size 4 got %d", sl.Size())
+	}
+	if val, ok, _ := sl.Get(key); !ok || val.Version != 9 {
+		t.Fatalf("Expected latest version 9 got %d", val.Version)
+	}
+}
+
+func TestSkipList_retentionOldestSnapshot(t *testing.T) {
+	snapshot := uint64(4)
+	sl := NewSkipList(1024, 0.5, WithRetention(RetentionPolicy{OldestSnapshot: func() uint64 { return snapshot }}))
+	key := []byte("hot")
+	for v := range uint64(10) {
+		sl.Put(key, key, v)
+	}
+	// the snapshot at 4 reads version 4, versions 5 to 9 may be read by newer snapshots
+	if sl.Size() != 6 {
+		t.Fatalf("Expected size 6 got %d", sl.Size())
+	}
+	mem := sl.MemoryUsage()
+
+	snapshot = 100
+	if pruned := sl.PruneVersions(); pruned != 5 {
+		t.Fatalf("Expected 5 pruned versions got %d", pruned)
+	}
+	if sl.Size() != 1 {
+		t.Fatalf("Expected size 1 got %d", sl.Size())
+	}
+	if sl.MemoryUsage() != mem-5*versionOverhead {
+		t.Fatalf("Expected memory usage to shrink to %d got %d", mem-5*versionOverhead, sl.MemoryUsage())
+	}
+}
+
+func TestSkipList_retentionKeepFor(t *testing.T) {
+	sl := NewSkipList(1024, 0.5, WithRetention(RetentionPolicy{KeepFor: 50 * time.Millisecond}))
+	key := []byte("hot")
+	for v := range uint64(5) {
+		sl.Put(key, key, v)
+	}
+	if sl.Size() != 5 {
+		t.Fatalf("Expected recent versions to be kept, size %d", sl.Size())
+	}
+	time.Sleep(60 * time.Millisecond)
+	sl.Put(key, key, 5)
+	if sl.Size() != 1 {
+		t.Fatalf("Expected expired versions to be pruned, size %d", sl.Size())
+	}
+}
+
+func TestSkipList_seekAndReverse(t *testing.T) {
+	sl := NewSkipList(1024, 0.5)
+	var keys [][]byte
+	for i := 0; i < 100; i += 2 {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		keys = append(keys, key)
+		sl.Put(key, key, uint64(i))
+	}
+
+	if it := sl.Seek([]byte("key-010")); it.Dref() == nil || !bytes.Equal(it.Dref().Key, []byte("key-010")) {
+		t.Fatalf("Expected seek to an existing key to land on it, got %v", it.Dref())
+	}
+	if it := sl.Seek([]byte("key-011")); it.Dref() == nil || !bytes.Equal(it.Dref().Key, []byte("key-012")) {
+		t.Fatalf("Expected seek to land on the next key, got %v", it.Dref())
+	}
+	if it := sl.Seek([]byte("a")); it.Dref() == nil || !bytes.Equal(it.Dref().Key, keys[0]) {
+		t.Fatalf("Expected seek before the first key to land on it, got %v", it.Dref())
+	}
+	if it := sl.Seek([]byte("z")); it.Dref() != nil {
+		t.Fatalf("Expected seek past the last key to be exhausted, got %s", it.Dref().Key)
+	}
+
+	it := sl.SeekToLast()
+	for i := len(keys) - 1; i >= 0; i-- {
+		if it.Dref() == nil || !bytes.Equal(it.Dref().Key, keys[i]) {
+			t.Fatalf("Expected %s got %v", keys[i], it.Dref())
+		}
+		it.Prev()
+	}
+	if it.Dref() != nil {
+		t.Fatalf("Expected Prev of the first key to end the iterator, got %s", it.Dref().Key)
+	}
+
+	// Next and Prev can be mixed
+	it = sl.Seek([]byte("key-050"))
+	it.Next()
+	it.Prev()
+	it.Prev()
+	if it.Dref() == nil || !bytes.Equal(it.Dref().Key, []byte("key-048")) {
+		t.Fatalf("Expected key-048 got %v", it.Dref())
+	}
+
+	if it := NewSkipList(1024, 0.5).SeekToLast(); it.Dref() != nil {
+		t.Fatalf("Expected an empty list to have no last key")
+	}
+}
+
+func TestSkipList_seekEmptyKey(t *testing.T) {
+	sl := NewSkipList(1024, 0.5)
+	sl.Put([]byte("b"), []byte("b"), 1)
+	sl.Put([]byte("a"), []byte("a"), 2)
+	if it := sl.Seek(nil); it.Dref() == nil || !bytes.Equal(it.Dref().Key, []byte("a")) {
+		t.Fatalf("Expected Seek(nil) to land on the first key, got %v", it.Dref())
+	}
+
+	// a nil key sorts first and Prev from it has to end, not start over from the last key
+	sl.Put(nil, []byte("empty"), 3)
+	if it := sl.Seek(nil); it.Dref() == nil || len(it.Dref().Key) != 0 {
+		t.Fatalf("Expected Seek(nil) to land on the empty key, got %v", it.Dref())
+	}
+	var keys []string
+	for it := sl.SeekToLast(); it.Dref() != nil && len(keys) < 10; it.Prev() {
+		keys = append(keys, string(it.Dref().Key))
+	}
+	if fmt.Sprintf("%q", keys) != `["b" "a" ""]` {
+		t.Fatalf("Expected [b a \"\"] got %q", keys)
+	}
+}
+
+func TestSkipList_iteratorVersions(t *testing.T) {
+	sl := NewSkipList(1024, 0.5)
+	key := []byte("key")
+	for v := uint64(1); v <= 5; v++ {
+		sl.Put(key, []byte(fmt.Sprintf("val-%d", v)), v*10)
+	}
+
+	it := sl.Seek(key)
+	versions := it.Versions()
+	if len(versions) != 5 {
+		t.Fatalf("Expected 5 versions got %d", len(versions))
+	}
+	for i, v := range versions {
+		if v.Version != uint64(50-10*i) {
+			t.Fatalf("Expected versions newest first, got %d at %d", v.Version, i)
+		}
+	}
+	if v, ok := it.AtSnapshot(35); !ok || v.Version != 30 || !bytes.Equal(v.Value, []byte("val-3")) {
+		t.Fatalf("Expected version 30 at snapshot 35 got %d %s", v.Version, v.Value)
+	}
+	if _, ok := it.AtSnapshot(5); ok {
+		t.Fatalf("Expected no version at snapshot 5")
+	}
+}
+
+func TestSkipList_seededShapeIsReproducible(t *testing.T) {
+	build := func() *SkipList {
+		sl := NewSkipList(1024, 0.25, WithLevelSource(probability.NewSplitMix64(99)))
+		for i := range 500 {
+			key := []byte(fmt.Sprintf("key-%03d", i))
+			sl.Put(key, key, uint64(i))
+		}
+		return sl
+	}
+	a, b := build(), build()
+	for i := range 500 {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		_, _, stepsA := a.Get(key)
+		_, _, stepsB := b.Get(key)
+		if stepsA != stepsB {
+			t.Fatalf("Expected the same shape for the same seed, %s took %d and %d steps", key, stepsA, stepsB)
+		}
+	}
+	if a.MemoryUsage() != b.MemoryUsage() {
+		t.Fatalf("Expected the same node heights, memory %d and %d", a.MemoryUsage(), b.MemoryUsage())
+	}
+}
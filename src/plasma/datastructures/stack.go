@@ -1,31 +1,136 @@
 package datastructures
 
-import "github.com/cloudnoize/el_gokv/src/plasma/utils"
+import "errors"
 
+var (
+	ErrEmptyStack = errors.New("stack is empty")
+	ErrStackIndex = errors.New("stack index out of range")
+)
+
+// unbounded stacks don't shrink below minShrinkCap
+const minShrinkCap = 16
+
+// Stack is a ring buffer, so dropping from the bottom and overwriting the oldest element
+// of a bounded stack don't move the rest.
 type Stack[T any] struct {
-	stack []T
-	idx   int
+	stack  []T
+	bottom int
+	size   int
+	// bound is the max size of a bounded stack, 0 means unbounded
+	bound int
+}
+
+// NewBoundedStack returns a stack that keeps the last bound elements, a Push on a full stack overwrites the oldest.
+func NewBoundedStack[T any](bound int) *Stack[T] {
+	return &Stack[T]{stack: make([]T, bound), bound: bound}
 }
 
 func (s *Stack[T]) Size() int {
-	return s.idx
+	return s.size
+}
+
+// slot is the index in the buffer of the i'th element from the bottom.
+func (s *Stack[T]) slot(i int) int {
+	return (s.bottom + i) % len(s.stack)
 }
 
 func (s *Stack[T]) Push(e T) {
-	if s.idx == len(s.stack) {
-		s.stack = append(s.stack, e)
-	} else {
-		s.stack[s.idx] = e
+	if s.bound > 0 && s.size == s.bound {
+		s.stack[s.bottom] = e
+		s.bottom = s.slot(1)
+		return
+	}
+	if s.size == len(s.stack) {
+		s.resize(max(2*len(s.stack), 1))
 	}
-	s.idx++
+	s.stack[s.slot(s.size)] = e
+	s.size++
 }
 
-func (s *Stack[T]) Pop() {
-	utils.Assert(s.Size() > 0, "Trying to pop from empty stack")
-	s.idx--
+// resize copies the elements to a buffer of newCap with the bottom at 0.
+func (s *Stack[T]) resize(newCap int) {
+	stack := make([]T, newCap)
+	for i := range s.size {
+		stack[i] = s.stack[s.slot(i)]
+	}
+	s.stack = stack
+	s.bottom = 0
 }
 
-func (s *Stack[T]) Top() T {
-	utils.Assert(s.Size() > 0, "Trying to pop from empty stack")
-	return s.stack[s.idx-1]
+// shrink gives memory back once less than a quarter of an unbounded stack is in use.
+func (s *Stack[T]) shrink() {
+	if s.bound == 0 && len(s.stack) > minShrinkCap && s.size < len(s.stack)/4 {
+		s.resize(max(2*s.size, minShrinkCap))
+	}
+}
+
+func (s *Stack[T]) Pop() (T, error) {
+	var zero T
+	if s.size == 0 {
+		return zero, ErrEmptyStack
+	}
+	top := s.slot(s.size - 1)
+	e := s.stack[top]
+	// zero the slot so the popped value can be collected
+	s.stack[top] = zero
+	s.size--
+	s.shrink()
+	return e, nil
+}
+
+func (s *Stack[T]) Top() (T, error) {
+	return s.Peek(0)
+}
+
+// Peek returns the i'th element from the top, Peek(0) is Top.
+func (s *Stack[T]) Peek(i int) (T, error) {
+	var zero T
+	if s.size == 0 {
+		return zero, ErrEmptyStack
+	}
+	if i < 0 || i >= s.size {
+		return zero, ErrStackIndex
+	}
+	return s.stack[s.slot(s.size-1-i)], nil
+}
+
+// DropBottom removes the n oldest elements.
+func (s *Stack[T]) DropBottom(n int) error {
+	if n < 0 || n > s.size {
+		return ErrStackIndex
+	}
+	var zero T
+	for i := range n {
+		s.stack[s.slot(i)] = zero
+	}
+	if n > 0 {
+		s.bottom = s.slot(n)
+	}
+	s.size -= n
+	s.shrink()
+	return nil
+}
+
+// StackIterator walks a stack from the top down, it's invalidated by changes to the stack.
+type StackIterator[T any] struct {
+	s *Stack[T]
+	i int
+}
+
+func (s *Stack[T]) Iterator() *StackIterator[T] {
+	return &StackIterator[T]{s: s}
+}
+
+func (it *StackIterator[T]) Next() {
+	if it.i < it.s.size {
+		it.i++
+	}
+}
+
+// Dref returns nil once the iterator passed the bottom.
+func (it *StackIterator[T]) Dref() *T {
+	if it.i >= it.s.size {
+		return nil
+	}
+	return &it.s.stack[it.s.slot(it.s.size-1-it.i)]
 }
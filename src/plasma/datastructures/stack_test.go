@@ -1,20 +1,41 @@
 package datastructures
 
 import (
+	"errors"
 	"testing"
 )
 
 // --- helpers ---
 
-// assertPanics runs f and fails the test if it does NOT panic.
-func assertPanics(t *testing.T, name string, f func()) {
+// mustTop fails the test if the stack is empty.
+func mustTop[T any](t *testing.T, s *Stack[T]) T {
 	t.Helper()
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fatalf("%s: expected panic, but function did not panic", name)
+	top, err := s.Top()
+	if err != nil {
+		t.Fatalf("Top() error = %v", err)
+	}
+	return top
+}
+
+// topDown collects the stack through its iterator.
+func topDown[T any](s *Stack[T]) []T {
+	var out []T
+	for it := s.Iterator(); it.Dref() != nil; it.Next() {
+		out = append(out, *it.Dref())
+	}
+	return out
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
-	}()
-	f()
+	}
+	return true
 }
 
 // --- tests ---
@@ -36,17 +57,19 @@ func TestStack_Int_PushTopPopSize(t *testing.T) {
 	}
 
 	// Top should be last pushed
-	top := s.Top()
+	top := mustTop(t, &s)
 	if top != 30 {
 		t.Fatalf("Top() = %d, want 30", top)
 	}
 
 	// Pop one and re-check
-	s.Pop()
+	if popped, err := s.Pop(); err != nil || popped != 30 {
+		t.Fatalf("Pop() = %d %v, want 30", popped, err)
+	}
 	if s.Size() != 2 {
 		t.Fatalf("Size() after Pop = %d, want 2", s.Size())
 	}
-	top = s.Top()
+	top = mustTop(t, &s)
 	if top != 20 {
 		t.Fatalf("Top() = %d, want 20", top)
 	}
@@ -67,23 +90,109 @@ func TestStack_String(t *testing.T) {
 	if s.Size() != 2 {
 		t.Fatalf("Size() = %d, want 2", s.Size())
 	}
-	if got := s.Top(); got != "b" {
+	if got := mustTop(t, &s); got != "b" {
 		t.Fatalf("Top() = %q, want %q", got, "b")
 	}
 	s.Pop()
-	if got := s.Top(); got != "a" {
+	if got := mustTop(t, &s); got != "a" {
 		t.Fatalf("Top() after Pop = %q, want %q", got, "a")
 	}
 }
 
-func TestStack_PopOnEmpty_Panics(t *testing.T) {
+func TestStack_PopOnEmpty_Errors(t *testing.T) {
+	var s Stack[int]
+	if _, err := s.Pop(); !errors.Is(err, ErrEmptyStack) {
+		t.Fatalf("Pop() on empty error = %v, want %v", err, ErrEmptyStack)
+	}
+}
+
+func TestStack_TopOnEmpty_Errors(t *testing.T) {
 	var s Stack[int]
-	assertPanics(t, "Pop on empty", func() { s.Pop() })
+	if _, err := s.Top(); !errors.Is(err, ErrEmptyStack) {
+		t.Fatalf("Top() on empty error = %v, want %v", err, ErrEmptyStack)
+	}
 }
 
-func TestStack_TopOnEmpty_Panics(t *testing.T) {
+func TestStack_PeekAndIterator(t *testing.T) {
 	var s Stack[int]
-	assertPanics(t, "Top on empty", func() { _ = s.Top() })
+	for i := range 5 {
+		s.Push(i)
+	}
+	for i := range 5 {
+		if got, err := s.Peek(i); err != nil || got != 4-i {
+			t.Fatalf("Peek(%d) = %d %v, want %d", i, got, err, 4-i)
+		}
+	}
+	if _, err := s.Peek(5); !errors.Is(err, ErrStackIndex) {
+		t.Fatalf("Peek(5) error = %v, want %v", err, ErrStackIndex)
+	}
+	if got := topDown(&s); !equalInts(got, []int{4, 3, 2, 1, 0}) {
+		t.Fatalf("iterator = %v, want [4 3 2 1 0]", got)
+	}
+}
+
+func TestStack_DropBottom(t *testing.T) {
+	var s Stack[int]
+	for i := range 100 {
+		s.Push(i)
+	}
+	if err := s.DropBottom(10); err != nil {
+		t.Fatalf("DropBottom(10) error = %v", err)
+	}
+	bottom, _ := s.Peek(s.Size() - 1)
+	if s.Size() != 90 || bottom != 10 || mustTop(t, &s) != 99 {
+		t.Fatalf("expected 90 elements from 10 to 99, got %d from %d to %d", s.Size(), bottom, mustTop(t, &s))
+	}
+	// dropping below a quarter gives the memory back
+	s.DropBottom(85)
+	if s.Size() != 5 || len(s.stack) != minShrinkCap {
+		t.Fatalf("expected 5 elements in a %d slots buffer, got %d in %d", minShrinkCap, s.Size(), len(s.stack))
+	}
+	if got := topDown(&s); !equalInts(got, []int{99, 98, 97, 96, 95}) {
+		t.Fatalf("iterator = %v, want [99 98 97 96 95]", got)
+	}
+	s.Push(100)
+	if mustTop(t, &s) != 100 || s.Size() != 6 {
+		t.Fatalf("expected push after drop to work, top %d size %d", mustTop(t, &s), s.Size())
+	}
+	if err := s.DropBottom(7); !errors.Is(err, ErrStackIndex) {
+		t.Fatalf("DropBottom past size error = %v, want %v", err, ErrStackIndex)
+	}
+}
+
+func TestStack_ShrinkOnPop(t *testing.T) {
+	var s Stack[int]
+	for i := range 1024 {
+		s.Push(i)
+	}
+	for range 1000 {
+		s.Pop()
+	}
+	if len(s.stack) >= 1024/4 {
+		t.Fatalf("expected the buffer to shrink, %d slots for %d elements", len(s.stack), s.Size())
+	}
+	if got := mustTop(t, &s); got != 23 {
+		t.Fatalf("Top() = %d, want 23", got)
+	}
+}
+
+func TestStack_BoundedOverwritesOldest(t *testing.T) {
+	s := NewBoundedStack[int](3)
+	for i := range 5 {
+		s.Push(i)
+	}
+	if s.Size() != 3 {
+		t.Fatalf("Size() = %d, want 3", s.Size())
+	}
+	if got := topDown(s); !equalInts(got, []int{4, 3, 2}) {
+		t.Fatalf("iterator = %v, want [4 3 2]", got)
+	}
+	s.Pop()
+	s.Push(5)
+	s.Push(6)
+	if got := topDown(s); !equalInts(got, []int{6, 5, 3}) {
+		t.Fatalf("iterator = %v, want [6 5 3]", got)
+	}
 }
 
 // --- benchmarks ---
@@ -118,7 +227,7 @@ func BenchmarkStackTop(b *testing.B) {
 	b.ResetTimer()
 	var sink int
 	for i := 0; i < b.N; i++ {
-		sink = s.Top()
+		sink, _ = s.Top()
 	}
 	_ = sink
 }
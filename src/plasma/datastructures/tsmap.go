@@ -2,48 +2,110 @@ package datastructures
 
 import (
 	"bytes"
-	"hash/fnv"
 	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
+)
+
+const (
+	// the table doubles once there are more entries than loadFactor per bucket
+	loadFactor = 2
+	// buckets moved to the new table by every operation while resizing
+	migrateBatch = 2
 )
 
 type Node[T any] struct {
-	key   []byte
-	value T
-	next  *Node[T]
+	key     []byte
+	hash    uint64
+	version uint64
+	value   T
+	next    *Node[T]
 }
 
 type Bucket[T any] struct {
 	head Node[T]
 	lock sync.RWMutex
+	// migrated is set under lock on buckets of the old table once their nodes moved to the new one
+	migrated bool
+}
+
+type table[T any] struct {
+	buckets    []Bucket[T]
+	numBuckets uint64
+}
+
+func newTable[T any](numBuckets uint64) *table[T] {
+	return &table[T]{buckets: make([]Bucket[T], numBuckets), numBuckets: numBuckets}
 }
 
 // TODO bench against golang conc map
+// TSMap grows by doubling with incremental rehashing, while old is set every operation
+// moves a few of its buckets to curr, so there is never a stop the world rehash.
 type TSMap[T any] struct {
-	buckets    []Bucket[T]
-	nunBuckets uint64
+	hasher probability.Hasher
+	// resizeLock is held for reading by every operation, and for writing only to swap the tables
+	resizeLock sync.RWMutex
+	curr       *table[T]
+	old        *table[T]
+	migrateIdx uint64
+	migrated   uint64
 	size       uint64
+	keys       uint64
 }
 
 func NewTSMap[T any](numBuckets uint64) *TSMap[T] {
-	return &TSMap[T]{buckets: make([]Bucket[T], numBuckets, numBuckets), nunBuckets: numBuckets}
+	return NewTSMapWithHasher[T](numBuckets, probability.DefaultHasher)
+}
+
+func NewTSMapWithHasher[T any](numBuckets uint64, hasher probability.Hasher) *TSMap[T] {
+	return &TSMap[T]{hasher: hasher, curr: newTable[T](max(numBuckets, 1))}
+}
+
+// lockBucket returns the bucket that holds h, locked. It's the old table bucket as long as it
+// wasn't migrated, the migration takes the same lock so it can't move the nodes under us.
+// Must be called with resizeLock held for reading.
+func (m *TSMap[T]) lockBucket(h uint64, write bool) *Bucket[T] {
+	lock := func(b *Bucket[T]) {
+		if write {
+			b.lock.Lock()
+		} else {
+			b.lock.RLock()
+		}
+	}
+	if old := m.old; old != nil {
+		b := &old.buckets[h%old.numBuckets]
+		lock(b)
+		if !b.migrated {
+			return b
+		}
+		unlockBucket(b, write)
+	}
+	b := &m.curr.buckets[h%m.curr.numBuckets]
+	lock(b)
+	return b
 }
 
-func hash(key []byte) uint64 {
-	h := fnv.New64a()
-	h.Write(key)
-	return h.Sum64()
+func unlockBucket[T any](b *Bucket[T], write bool) {
+	if write {
+		b.lock.Unlock()
+	} else {
+		b.lock.RUnlock()
+	}
 }
 
 // Get the latest version
 func (m *TSMap[T]) Get(key []byte) (T, bool) {
 	var zero T
-	h := hash(key)
-	idx := h % m.nunBuckets
-	m.buckets[idx].lock.RLock()
-	defer m.buckets[idx].lock.RUnlock()
-	curr := m.buckets[idx].head.next
+	h := m.hasher.Hash(key)
+	m.resizeLock.RLock()
+	defer m.endOp()
+	b := m.lockBucket(h, false)
+	defer b.lock.RUnlock()
+	curr := b.head.next
 	for curr != nil {
-		if bytes.Equal(key, curr.key) {
+		if curr.hash == h && bytes.Equal(key, curr.key) {
 			return curr.value, true
 		}
 		curr = curr.next
@@ -51,19 +113,261 @@ func (m *TSMap[T]) Get(key []byte) (T, bool) {
 	return zero, false
 }
 
-// Put will not try to find the key and update, but inserts a key first
-// and knows that get will return on first match
+// Put is PutVersion with version 0, for values that don't carry a version.
 func (m *TSMap[T]) Put(key []byte, value T) {
-	h := hash(key)
-	idx := h % m.nunBuckets
-	nn := &Node[T]{key: key, value: value}
-	m.buckets[idx].lock.Lock()
-	defer m.buckets[idx].lock.Unlock()
-	nn.next = m.buckets[idx].head.next
-	m.buckets[idx].head.next = nn
-	m.size++
+	m.PutVersion(key, value, 0)
+}
+
+// PutVersion will not update the key in place, but inserts a new node first
+// and knows that get will return on first match. Versions of a key must be put in increasing order.
+func (m *TSMap[T]) PutVersion(key []byte, value T, version uint64) {
+	m.put(key, value, version, false)
 }
 
+// PutLatest keeps a single node per key and updates it in place, for when the older versions
+// are kept elsewhere and the map only serves the latest one.
+func (m *TSMap[T]) PutLatest(key []byte, value T, version uint64) {
+	m.put(key, value, version, true)
+}
+
+func (m *TSMap[T]) put(key []byte, value T, version uint64, inPlace bool) {
+	h := m.hasher.Hash(key)
+	m.resizeLock.RLock()
+	b := m.lockBucket(h, true)
+	latest := b.find(h, key)
+	if latest != nil && inPlace {
+		latest.value, latest.version = value, version
+		b.lock.Unlock()
+		m.endOp()
+		return
+	}
+	if latest == nil {
+		atomic.AddUint64(&m.keys, 1)
+	}
+	nn := &Node[T]{key: key, hash: h, version: version, value: value}
+	nn.next = b.head.next
+	b.head.next = nn
+	b.lock.Unlock()
+	size := atomic.AddUint64(&m.size, 1)
+	grow := m.old == nil && size > loadFactor*m.curr.numBuckets
+	m.endOp()
+	if grow {
+		m.startResize()
+	}
+}
+
+// Delete removes every version of key and reports whether it was there.
+func (m *TSMap[T]) Delete(key []byte) bool {
+	h := m.hasher.Hash(key)
+	m.resizeLock.RLock()
+	defer m.endOp()
+	b := m.lockBucket(h, true)
+	defer b.lock.Unlock()
+	removed := uint64(0)
+	for prev := &b.head; prev.next != nil; {
+		if prev.next.hash == h && bytes.Equal(key, prev.next.key) {
+			prev.next = prev.next.next
+			removed++
+			continue
+		}
+		prev = prev.next
+	}
+	if removed == 0 {
+		return false
+	}
+	atomic.AddUint64(&m.size, ^(removed - 1))
+	atomic.AddUint64(&m.keys, ^uint64(0))
+	return true
+}
+
+// Range calls fn with the latest value of every key, until fn returns false.
+// The keys are collected while every other operation is blocked, so fn sees a single point in time,
+// and fn is called after the map is released so it may use the map.
+func (m *TSMap[T]) Range(fn func(key []byte, value T) bool) {
+	// values are copied out, PutLatest may change a node once the map is released
+	var latest []Node[T]
+	// a key lives in a single bucket, and nothing migrates while we hold the lock
+	seen := make(map[string]struct{})
+	m.resizeLock.Lock()
+	collect := func(b *Bucket[T]) {
+		for curr := b.head.next; curr != nil; curr = curr.next {
+			if _, ok := seen[string(curr.key)]; !ok {
+				seen[string(curr.key)] = struct{}{}
+				latest = append(latest, Node[T]{key: curr.key, value: curr.value})
+			}
+		}
+	}
+	if m.old != nil {
+		for i := range m.old.buckets {
+			collect(&m.old.buckets[i])
+		}
+	}
+	for i := range m.curr.buckets {
+		collect(&m.curr.buckets[i])
+	}
+	m.resizeLock.Unlock()
+	for _, n := range latest {
+		if !fn(n.key, n.value) {
+			return
+		}
+	}
+}
+
+// PruneOlderThan drops the versions no reader at version or later can see, for every key
+// it keeps the versions above version and the latest one at or below it. Returns the number of dropped versions.
+func (m *TSMap[T]) PruneOlderThan(version uint64) uint64 {
+	m.resizeLock.RLock()
+	defer m.resizeLock.RUnlock()
+	pruned := uint64(0)
+	visible := make(map[string]struct{})
+	prune := func(b *Bucket[T]) {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.migrated {
+			return
+		}
+		// the chain is newest first, so the first node at or below version is the one readers see
+		clear(visible)
+		for prev := &b.head; prev.next != nil; {
+			curr := prev.next
+			if curr.version <= version {
+				if _, ok := visible[string(curr.key)]; ok {
+					prev.next = curr.next
+					pruned++
+					continue
+				}
+				visible[string(curr.key)] = struct{}{}
+			}
+			prev = curr
+		}
+	}
+	// the old table goes first, buckets it migrates meanwhile are then pruned in curr
+	if m.old != nil {
+		for i := range m.old.buckets {
+			prune(&m.old.buckets[i])
+		}
+	}
+	for i := range m.curr.buckets {
+		prune(&m.curr.buckets[i])
+	}
+	if pruned > 0 {
+		atomic.AddUint64(&m.size, ^(pruned - 1))
+	}
+	return pruned
+}
+
+// find returns the latest node of key, must be called with the bucket locked.
+func (b *Bucket[T]) find(h uint64, key []byte) *Node[T] {
+	for curr := b.head.next; curr != nil; curr = curr.next {
+		if curr.hash == h && bytes.Equal(key, curr.key) {
+			return curr
+		}
+	}
+	return nil
+}
+
+// endOp moves a few buckets if a resize is going on and releases resizeLock.
+func (m *TSMap[T]) endOp() {
+	done := m.migrateStep()
+	m.resizeLock.RUnlock()
+	if done {
+		m.resizeLock.Lock()
+		m.old = nil
+		// puts during the migration didn't grow the map, it may already be over the load factor
+		m.growLocked()
+		m.resizeLock.Unlock()
+	}
+}
+
+func (m *TSMap[T]) startResize() {
+	m.resizeLock.Lock()
+	defer m.resizeLock.Unlock()
+	m.growLocked()
+}
+
+// growLocked doubles the table if it's over the load factor and no resize is going on.
+// Must be called with resizeLock held for writing.
+func (m *TSMap[T]) growLocked() {
+	if m.old != nil || atomic.LoadUint64(&m.size) <= loadFactor*m.curr.numBuckets {
+		return
+	}
+	m.old = m.curr
+	m.curr = newTable[T](2 * m.old.numBuckets)
+	m.migrateIdx = 0
+	m.migrated = 0
+}
+
+// migrateStep migrates up to migrateBatch buckets of the old table and reports whether
+// it migrated the last one. Must be called with resizeLock held for reading.
+func (m *TSMap[T]) migrateStep() bool {
+	old := m.old
+	if old == nil {
+		return false
+	}
+	done := false
+	for range migrateBatch {
+		idx := atomic.AddUint64(&m.migrateIdx, 1) - 1
+		if idx >= old.numBuckets {
+			break
+		}
+		m.migrateBucket(old, idx)
+		done = atomic.AddUint64(&m.migrated, 1) == old.numBuckets
+	}
+	return done
+}
+
+// migrateBucket moves the chain of old bucket idx to curr keeping its order, so the latest version of a key
+// stays first. Its keys only go to curr buckets idx and idx+old.numBuckets, both are locked for the whole move
+// so no one, PruneOlderThan included, changes them under a saved tail or sees them half migrated.
+func (m *TSMap[T]) migrateBucket(old *table[T], idx uint64) {
+	b := &old.buckets[idx]
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	targets := [2]*Bucket[T]{&m.curr.buckets[idx], &m.curr.buckets[idx+old.numBuckets]}
+	var tails [2]*Node[T]
+	for i, target := range targets {
+		target.lock.Lock()
+		defer target.lock.Unlock()
+		tails[i] = &target.head
+	}
+	for curr := b.head.next; curr != nil; {
+		next := curr.next
+		i := 0
+		if curr.hash%m.curr.numBuckets != idx {
+			i = 1
+		}
+		curr.next = tails[i].next
+		tails[i].next = curr
+		tails[i] = curr
+		curr = next
+	}
+	b.head.next = nil
+	b.migrated = true
+}
+
+// Size is the number of entries, every version of a key is an entry.
 func (m *TSMap[T]) Size() uint64 {
-	return m.size
+	return atomic.LoadUint64(&m.size)
+}
+
+// KeyCount is the number of distinct keys.
+func (m *TSMap[T]) KeyCount() uint64 {
+	return atomic.LoadUint64(&m.keys)
+}
+
+func (m *TSMap[T]) NumBuckets() uint64 {
+	m.resizeLock.RLock()
+	defer m.resizeLock.RUnlock()
+	return m.curr.numBuckets
+}
+
+// MemoryUsage is what the buckets and nodes take, the keys and values are owned by the caller
+func (m *TSMap[T]) MemoryUsage() uint64 {
+	m.resizeLock.RLock()
+	defer m.resizeLock.RUnlock()
+	buckets := m.curr.numBuckets
+	if m.old != nil {
+		buckets += m.old.numBuckets
+	}
+	return buckets*uint64(unsafe.Sizeof(Bucket[T]{})) + m.Size()*uint64(unsafe.Sizeof(Node[T]{}))
 }
@@ -3,9 +3,12 @@ package datastructures
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
 	"sync"
 	"sync/atomic"
 	"testing"
+
+	"github.com/cloudnoize/el_gokv/src/plasma/probability"
 )
 
 // ---------------------
@@ -80,6 +83,227 @@ func TestTSMap_ConcurrentAccess(t *testing.T) {
 	}
 }
 
+func TestTSMap_GrowKeepsLatestVersion(t *testing.T) {
+	m := NewTSMap[int](1)
+	numKeys := 1000
+
+	for v := range 3 {
+		for i := range numKeys {
+			m.Put([]byte(fmt.Sprintf("key-%d", i)), v)
+		}
+	}
+	if m.NumBuckets() < uint64(numKeys) {
+		t.Fatalf("expected the map to grow past %d buckets, got %d", numKeys, m.NumBuckets())
+	}
+	for i := range numKeys {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if got, ok := m.Get(key); !ok || got != 2 {
+			t.Fatalf("expected latest version 2 for %s got %d %v", key, got, ok)
+		}
+	}
+	if m.Size() != uint64(3*numKeys) {
+		t.Fatalf("expected size %d, got %d", 3*numKeys, m.Size())
+	}
+}
+
+func TestTSMap_ConcurrentAccessWhileGrowing(t *testing.T) {
+	m := NewTSMap[[]byte](1)
+	var wg sync.WaitGroup
+	numOps := 20000
+
+	for w := range 8 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < numOps; i += 8 {
+				key := []byte(fmt.Sprintf("key-%d", i))
+				val := []byte(fmt.Sprintf("val-%d", i))
+				m.Put(key, val)
+				got, ok := m.Get(key)
+				if !ok || !bytes.Equal(got, val) {
+					t.Errorf("expected %s got %s", val, got)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for i := range numOps {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if got, ok := m.Get(key); !ok || !bytes.Equal(got, []byte(fmt.Sprintf("val-%d", i))) {
+			t.Fatalf("expected val-%d got %s", i, got)
+		}
+	}
+	if m.NumBuckets() < uint64(numOps/loadFactor) {
+		t.Fatalf("expected at least %d buckets got %d", numOps/loadFactor, m.NumBuckets())
+	}
+}
+
+func TestTSMap_Delete(t *testing.T) {
+	m := NewTSMap[[]byte](16)
+	m.Put([]byte("a"), []byte("1"))
+	m.Put([]byte("a"), []byte("2"))
+	m.Put([]byte("b"), []byte("3"))
+
+	if !m.Delete([]byte("a")) {
+		t.Fatalf("expected a to be deleted")
+	}
+	if m.Delete([]byte("a")) {
+		t.Fatalf("expected a to be gone already")
+	}
+	if _, ok := m.Get([]byte("a")); ok {
+		t.Fatalf("expected a not to be found")
+	}
+	if got, ok := m.Get([]byte("b")); !ok || !bytes.Equal(got, []byte("3")) {
+		t.Fatalf("expected b=3 got %s", got)
+	}
+	if m.Size() != 1 || m.KeyCount() != 1 {
+		t.Fatalf("expected 1 entry and 1 key, got %d and %d", m.Size(), m.KeyCount())
+	}
+}
+
+func TestTSMap_RangeLatest(t *testing.T) {
+	m := NewTSMap[int](1)
+	for v := range 3 {
+		for i := range 100 {
+			m.Put([]byte(fmt.Sprintf("key-%d", i)), v)
+		}
+	}
+	if m.KeyCount() != 100 || m.Size() != 300 {
+		t.Fatalf("expected 100 keys and 300 entries, got %d and %d", m.KeyCount(), m.Size())
+	}
+
+	seen := make(map[string]int)
+	m.Range(func(key []byte, value int) bool {
+		seen[string(key)] = value
+		return true
+	})
+	if len(seen) != 100 {
+		t.Fatalf("expected 100 keys got %d", len(seen))
+	}
+	for k, v := range seen {
+		if v != 2 {
+			t.Fatalf("expected latest value 2 for %s got %d", k, v)
+		}
+	}
+
+	calls := 0
+	m.Range(func(key []byte, value int) bool {
+		calls++
+		return calls < 10
+	})
+	if calls != 10 {
+		t.Fatalf("expected Range to stop after 10 calls got %d", calls)
+	}
+}
+
+func TestTSMap_PruneOlderThan(t *testing.T) {
+	m := NewTSMap[uint64](16)
+	key := []byte("hot")
+	for v := uint64(1); v <= 10; v++ {
+		m.PutVersion(key, v, v)
+	}
+	m.PutVersion([]byte("cold"), 1, 1)
+
+	// a snapshot at 5 still needs version 5, everything below it is superseded
+	if pruned := m.PruneOlderThan(5); pruned != 4 {
+		t.Fatalf("expected 4 pruned versions got %d", pruned)
+	}
+	if m.Size() != 7 || m.KeyCount() != 2 {
+		t.Fatalf("expected 7 entries and 2 keys, got %d and %d", m.Size(), m.KeyCount())
+	}
+	if got, _ := m.Get(key); got != 10 {
+		t.Fatalf("expected latest 10 got %d", got)
+	}
+	if pruned := m.PruneOlderThan(100); pruned != 5 {
+		t.Fatalf("expected 5 pruned versions got %d", pruned)
+	}
+	if got, _ := m.Get([]byte("cold")); got != 1 {
+		t.Fatalf("expected cold to keep its only version, got %d", got)
+	}
+}
+
+func TestTSMap_ConcurrentDeletePruneRange(t *testing.T) {
+	m := NewTSMap[uint64](1)
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range 2000 {
+				key := []byte(fmt.Sprintf("key-%d-%d", w, i%100))
+				m.PutVersion(key, uint64(i), uint64(i))
+				if i%7 == 0 {
+					m.Delete(key)
+				}
+			}
+		}(w)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 50 {
+			m.PruneOlderThan(uint64(i * 40))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			m.Range(func(key []byte, value uint64) bool { return true })
+		}
+	}()
+	wg.Wait()
+
+	keys := uint64(0)
+	m.Range(func(key []byte, value uint64) bool {
+		keys++
+		return true
+	})
+	if keys != m.KeyCount() {
+		t.Fatalf("expected Range to see KeyCount %d keys, got %d", m.KeyCount(), keys)
+	}
+}
+
+func TestTSMap_PruneWhileGrowing(t *testing.T) {
+	m := NewTSMap[uint64](1)
+	numKeys := 4000
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	for w := range 4 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < numKeys; i += 4 {
+				key := []byte(fmt.Sprintf("key-%d", i))
+				m.PutVersion(key, 1, 1)
+				m.PutVersion(key, 2, 2)
+			}
+		}(w)
+	}
+	pruned := make(chan uint64)
+	go func() {
+		total := uint64(0)
+		for !done.Load() {
+			total += m.PruneOlderThan(2)
+		}
+		pruned <- total
+	}()
+	wg.Wait()
+	done.Store(true)
+	total := <-pruned + m.PruneOlderThan(2)
+
+	// a prune racing a migration must not lose the nodes migrated after it
+	for i := range numKeys {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if got, ok := m.Get(key); !ok || got != 2 {
+			t.Fatalf("expected version 2 of %s got %d %v", key, got, ok)
+		}
+	}
+	if m.Size() != uint64(numKeys) || total != uint64(numKeys) {
+		t.Fatalf("expected %d entries and %d pruned, got %d and %d", numKeys, numKeys, m.Size(), total)
+	}
+}
+
 // ---------------------
 // Benchmarks
 // ---------------------
@@ -118,6 +342,29 @@ func BenchmarkTSMap_Get(b *testing.B) {
 	}
 }
 
+// fnvHasher is the hash TSMap used before it took a probability.Hasher
+type fnvHasher struct{}
+
+func (fnvHasher) Hash(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+func BenchmarkTSMap_GetLongKey(b *testing.B) {
+	key := bytes.Repeat([]byte("k"), 256)
+	val := []byte("value")
+	for name, hasher := range map[string]probability.Hasher{"default": probability.DefaultHasher, "fnv": fnvHasher{}} {
+		m := NewTSMapWithHasher[[]byte](16, hasher)
+		m.Put(key, val)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Get(key)
+			}
+		})
+	}
+}
+
 func BenchmarkSyncMap_Get(b *testing.B) {
 	var m sync.Map
 	key := "key"
@@ -0,0 +1,193 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownKey = errors.New("encryption: unknown master key")
+	ErrBadHeader  = errors.New("encryption: bad file header")
+	// ErrAuth is returned when a record or a wrapped key was tampered with, or read with the wrong key or offset
+	ErrAuth = errors.New("encryption: authentication failed")
+)
+
+// KeySource hands out master keys, e.g. from a KMS or a key file. Old keys must stay
+// available until compaction rewrote every file that was written under them.
+type KeySource interface {
+	CurrentKeyID() uint32
+	MasterKey(id uint32) ([]byte, error)
+}
+
+// EncryptionProvider encrypts the WAL, tables and manifest with a data key per file.
+// The header is written at the start of the file and holds the data key wrapped by a master key.
+type EncryptionProvider interface {
+	NewFile() (header []byte, c FileCipher, err error)
+	OpenFile(header []byte) (FileCipher, error)
+	// NeedsRotation reports that the file was written under an old master key, compaction
+	// should rewrite it.
+	NeedsRotation(header []byte) (bool, error)
+}
+
+// FileCipher encrypts the records of one file. The offset of a record in the file is authenticated,
+// so a record can't be moved around the file.
+type FileCipher interface {
+	// Seal appends the encrypted record to dst
+	Seal(dst, record []byte, offset uint64) []byte
+	Open(dst, sealed []byte, offset uint64) ([]byte, error)
+	// Overhead is how many bytes Seal adds to a record
+	Overhead() int
+}
+
+type StaticKeySource struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func NewStaticKeySource(current uint32, keys map[uint32][]byte) *StaticKeySource {
+	return &StaticKeySource{current: current, keys: keys}
+}
+
+func (s *StaticKeySource) CurrentKeyID() uint32 {
+	return s.current
+}
+
+func (s *StaticKeySource) MasterKey(id uint32) ([]byte, error) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+const (
+	headerVersion = 1
+	dataKeySize   = 32
+	nonceSize     = 12
+	tagSize       = 16
+	// HeaderSize is version, master key id, nonce and the wrapped data key
+	HeaderSize = 1 + 4 + nonceSize + dataKeySize + tagSize
+)
+
+// AESGCMProvider wraps a random AES-256 data key per file with AES-GCM under the current master key
+// and seals records with AES-GCM under the data key.
+type AESGCMProvider struct {
+	keys KeySource
+}
+
+func NewAESGCMProvider(keys KeySource) *AESGCMProvider {
+	return &AESGCMProvider{keys: keys}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p *AESGCMProvider) NewFile() ([]byte, FileCipher, error) {
+	id := p.keys.CurrentKeyID()
+	master, err := p.keys.MasterKey(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrap, err := newGCM(master)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, dataKeySize)
+	rand.Read(dataKey)
+
+	header := make([]byte, 1+4+nonceSize, HeaderSize)
+	header[0] = headerVersion
+	binary.LittleEndian.PutUint32(header[1:], id)
+	rand.Read(header[5:])
+	// version and key id are authenticated with the wrapped key
+	header = wrap.Seal(header, header[5:], dataKey, header[:5])
+
+	c, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, gcmFileCipher{aead: c}, nil
+}
+
+func (p *AESGCMProvider) OpenFile(header []byte) (FileCipher, error) {
+	id, err := parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	master, err := p.keys.MasterKey(id)
+	if err != nil {
+		return nil, err
+	}
+	wrap, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := wrap.Open(nil, header[5:5+nonceSize], header[5+nonceSize:], header[:5])
+	if err != nil {
+		return nil, fmt.Errorf("%w: data key of master key %d", ErrAuth, id)
+	}
+	c, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return gcmFileCipher{aead: c}, nil
+}
+
+func (p *AESGCMProvider) NeedsRotation(header []byte) (bool, error) {
+	id, err := parseHeader(header)
+	if err != nil {
+		return false, err
+	}
+	return id != p.keys.CurrentKeyID(), nil
+}
+
+func parseHeader(header []byte) (uint32, error) {
+	if len(header) != HeaderSize {
+		return 0, fmt.Errorf("%w: expected %d bytes got %d", ErrBadHeader, HeaderSize, len(header))
+	}
+	if header[0] != headerVersion {
+		return 0, fmt.Errorf("%w: version %d", ErrBadHeader, header[0])
+	}
+	return binary.LittleEndian.Uint32(header[1:]), nil
+}
+
+// gcmFileCipher writes a random nonce before every sealed record, with a fresh data key per file
+// 96 random bits are far from colliding.
+type gcmFileCipher struct {
+	aead cipher.AEAD
+}
+
+func (c gcmFileCipher) Overhead() int {
+	return nonceSize + c.aead.Overhead()
+}
+
+func (c gcmFileCipher) Seal(dst, record []byte, offset uint64) []byte {
+	var ad [8]byte
+	binary.LittleEndian.PutUint64(ad[:], offset)
+	start := len(dst)
+	dst = append(dst, make([]byte, nonceSize)...)
+	rand.Read(dst[start:])
+	return c.aead.Seal(dst, dst[start:], record, ad[:])
+}
+
+func (c gcmFileCipher) Open(dst, sealed []byte, offset uint64) ([]byte, error) {
+	if len(sealed) < c.Overhead() {
+		return nil, fmt.Errorf("%w: short record", ErrAuth)
+	}
+	var ad [8]byte
+	binary.LittleEndian.PutUint64(ad[:], offset)
+	out, err := c.aead.Open(dst, sealed[:nonceSize], sealed[nonceSize:], ad[:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: record at %d", ErrAuth, offset)
+	}
+	return out, nil
+}
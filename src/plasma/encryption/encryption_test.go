@@ -0,0 +1,110 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testKeys() *StaticKeySource {
+	return NewStaticKeySource(1, map[uint32][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	})
+}
+
+func TestAESGCM_RoundTrip(t *testing.T) {
+	p := NewAESGCMProvider(testKeys())
+	header, c, err := p.NewFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header) != HeaderSize {
+		t.Fatalf("expected header of %d bytes got %d", HeaderSize, len(header))
+	}
+	record := []byte("key1:value1")
+	sealed := c.Seal(nil, record, 4096)
+	if len(sealed) != len(record)+c.Overhead() {
+		t.Fatalf("expected %d bytes got %d", len(record)+c.Overhead(), len(sealed))
+	}
+	if bytes.Contains(sealed, record) {
+		t.Fatalf("expected the record to be encrypted")
+	}
+
+	// a reader only has the header
+	reopened, err := p.OpenFile(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Open(nil, sealed, 4096)
+	if err != nil || !bytes.Equal(got, record) {
+		t.Fatalf("expected %s got %s, %v", record, got, err)
+	}
+}
+
+func TestAESGCM_Tamper(t *testing.T) {
+	p := NewAESGCMProvider(testKeys())
+	header, c, _ := p.NewFile()
+	sealed := c.Seal(nil, []byte("record"), 0)
+
+	for i := range sealed {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		if _, err := c.Open(nil, tampered, 0); !errors.Is(err, ErrAuth) {
+			t.Fatalf("expected ErrAuth for a flipped byte %d got %v", i, err)
+		}
+	}
+	if _, err := c.Open(nil, sealed, 1); !errors.Is(err, ErrAuth) {
+		t.Fatalf("expected ErrAuth for a moved record got %v", err)
+	}
+	if _, err := c.Open(nil, sealed[:5], 0); !errors.Is(err, ErrAuth) {
+		t.Fatalf("expected ErrAuth for a short record got %v", err)
+	}
+
+	// the key id is authenticated, pointing it at another master key fails
+	tampered := bytes.Clone(header)
+	tampered[1] = 2
+	if _, err := p.OpenFile(tampered); !errors.Is(err, ErrAuth) {
+		t.Fatalf("expected ErrAuth for a tampered header got %v", err)
+	}
+	tampered = bytes.Clone(header)
+	tampered[HeaderSize-1] ^= 1
+	if _, err := p.OpenFile(tampered); !errors.Is(err, ErrAuth) {
+		t.Fatalf("expected ErrAuth for a tampered wrapped key got %v", err)
+	}
+	if _, err := p.OpenFile(header[:10]); !errors.Is(err, ErrBadHeader) {
+		t.Fatalf("expected ErrBadHeader got %v", err)
+	}
+	tampered = bytes.Clone(header)
+	tampered[1] = 9
+	if _, err := p.OpenFile(tampered); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey got %v", err)
+	}
+}
+
+func TestAESGCM_Rotation(t *testing.T) {
+	keys := testKeys()
+	p := NewAESGCMProvider(keys)
+	oldHeader, oldCipher, _ := p.NewFile()
+	sealed := oldCipher.Seal(nil, []byte("old"), 0)
+	if rotate, _ := p.NeedsRotation(oldHeader); rotate {
+		t.Fatalf("expected a file under the current key to not need rotation")
+	}
+
+	keys.current = 2
+	if rotate, _ := p.NeedsRotation(oldHeader); !rotate {
+		t.Fatalf("expected a file under the old key to need rotation")
+	}
+	// until compaction rewrites it the old file is still readable
+	c, err := p.OpenFile(oldHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Open(nil, sealed, 0); err != nil || string(got) != "old" {
+		t.Fatalf("expected old got %s, %v", got, err)
+	}
+	newHeader, _, _ := p.NewFile()
+	if id, _ := parseHeader(newHeader); id != 2 {
+		t.Fatalf("expected new files under key 2 got %d", id)
+	}
+}
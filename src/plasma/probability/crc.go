@@ -1 +1,79 @@
 package probability
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// castagnoli uses the SSE4.2/ARMv8 crc instructions when the cpu has them
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// crcMaskDelta is the leveldb mask, so a crc stored inside checksummed data doesn't checksum trivially
+const crcMaskDelta = 0xa282ead8
+
+type ChecksumType byte
+
+const (
+	ChecksumCRC32C ChecksumType = iota + 1
+	ChecksumXXHash64
+)
+
+func CRC32C(b []byte) uint32 {
+	return crc32.Checksum(b, castagnoli)
+}
+
+// ExtendCRC32C continues crc with b, for records written in pieces.
+func ExtendCRC32C(crc uint32, b []byte) uint32 {
+	return crc32.Update(crc, castagnoli, b)
+}
+
+func MaskCRC(crc uint32) uint32 {
+	return ((crc >> 15) | (crc << 17)) + crcMaskDelta
+}
+
+func UnmaskCRC(masked uint32) uint32 {
+	rot := masked - crcMaskDelta
+	return (rot >> 17) | (rot << 15)
+}
+
+// Checksum is what goes to disk next to b, the masked crc32c or the xxhash64 of b.
+// It panics on an unknown type, the writer picks the type, the read path goes through VerifyChecksum.
+func Checksum(t ChecksumType, b []byte) uint64 {
+	switch t {
+	case ChecksumCRC32C:
+		return uint64(MaskCRC(CRC32C(b)))
+	case ChecksumXXHash64:
+		return XXHash64Sum(b, 0)
+	}
+	panic(fmt.Sprintf("unknown checksum type %d", t))
+}
+
+var ErrCorruption = errors.New("corruption")
+
+// CorruptionError tells where corrupt data was read from, errors.Is(err, ErrCorruption) matches it.
+type CorruptionError struct {
+	File   string
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corruption in %s at offset %d: %s", e.File, e.Offset, e.Reason)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruption
+}
+
+// VerifyChecksum checks b read from file at offset against the stored checksum want.
+// The type is read from disk too, an unknown one is corruption as well.
+func VerifyChecksum(t ChecksumType, b []byte, want uint64, file string, offset int64) error {
+	if t != ChecksumCRC32C && t != ChecksumXXHash64 {
+		return &CorruptionError{File: file, Offset: offset, Reason: fmt.Sprintf("unknown checksum type %d", t)}
+	}
+	if got := Checksum(t, b); got != want {
+		return &CorruptionError{File: file, Offset: offset, Reason: fmt.Sprintf("checksum mismatch, expected %x got %x", want, got)}
+	}
+	return nil
+}
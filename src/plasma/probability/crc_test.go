@@ -0,0 +1,57 @@
+package probability
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCRC32C_KnownValue(t *testing.T) {
+	if got := CRC32C([]byte("123456789")); got != 0xe3069283 {
+		t.Fatalf("crc32c = %x, want e3069283", got)
+	}
+	if got := ExtendCRC32C(CRC32C([]byte("1234")), []byte("56789")); got != 0xe3069283 {
+		t.Fatalf("extended crc32c = %x, want e3069283", got)
+	}
+}
+
+func TestMaskCRC_RoundTrip(t *testing.T) {
+	crc := CRC32C([]byte("foo"))
+	if MaskCRC(crc) == crc {
+		t.Fatalf("expected the masked crc to differ")
+	}
+	if got := UnmaskCRC(MaskCRC(crc)); got != crc {
+		t.Fatalf("unmask(mask(%x)) = %x", crc, got)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	block := []byte("some table block")
+	for _, ct := range []ChecksumType{ChecksumCRC32C, ChecksumXXHash64} {
+		sum := Checksum(ct, block)
+		if err := VerifyChecksum(ct, block, sum, "000001.sst", 4096); err != nil {
+			t.Fatalf("type %d: unexpected error %v", ct, err)
+		}
+
+		corrupt := append([]byte(nil), block...)
+		corrupt[3] ^= 1
+		err := VerifyChecksum(ct, corrupt, sum, "000001.sst", 4096)
+		if !errors.Is(err, ErrCorruption) {
+			t.Fatalf("type %d: expected ErrCorruption got %v", ct, err)
+		}
+		var cerr *CorruptionError
+		if !errors.As(err, &cerr) || cerr.File != "000001.sst" || cerr.Offset != 4096 {
+			t.Fatalf("type %d: expected the file and offset in the error, got %v", ct, err)
+		}
+	}
+}
+
+func TestVerifyChecksum_UnknownType(t *testing.T) {
+	block := []byte("some table block")
+	for _, ct := range []ChecksumType{0, ChecksumXXHash64 + 1, 0xff} {
+		err := VerifyChecksum(ct, block, 0, "000001.sst", 4096)
+		var cerr *CorruptionError
+		if !errors.As(err, &cerr) || !errors.Is(err, ErrCorruption) || cerr.Offset != 4096 {
+			t.Fatalf("type %d: expected a CorruptionError got %v", ct, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package probability
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// Hasher is a fast non cryptographic hash for in memory structures.
+type Hasher interface {
+	Hash(b []byte) uint64
+}
+
+// ProcessSeed is random per process so keys can't be crafted to collide (hash flooding).
+// Anything persisted, e.g. a bloom filter in a table file, must use a fixed seed stored next to it instead.
+var ProcessSeed = rand.Uint64()
+
+var DefaultHasher Hasher = NewWyHash(ProcessSeed)
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+type XXHash64 struct {
+	seed uint64
+}
+
+func NewXXHash64(seed uint64) XXHash64 {
+	return XXHash64{seed: seed}
+}
+
+func (x XXHash64) Hash(b []byte) uint64 {
+	return XXHash64Sum(b, x.seed)
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
+
+// XXHash64Sum is xxh64 of b with seed.
+func XXHash64Sum(b []byte, seed uint64) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(b) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+			b = b[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+const (
+	wyp0 uint64 = 0xa0761d6478bd642f
+	wyp1 uint64 = 0xe7037ed1a0b428db
+	wyp2 uint64 = 0x8ebc6af09c88c6db
+	wyp3 uint64 = 0x589965cc75374cc3
+)
+
+// WyHash follows wyhash, it reads 8 bytes at a time and mixes with a single 128 bit multiply.
+type WyHash struct {
+	seed uint64
+}
+
+func NewWyHash(seed uint64) WyHash {
+	return WyHash{seed: seed ^ wymix(seed^wyp0, wyp1)}
+}
+
+func wymix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func wyr8(b []byte) uint64 {
+	return binary.LittleEndian.Uint64(b)
+}
+
+func wyr4(b []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint32(b))
+}
+
+func (w WyHash) Hash(b []byte) uint64 {
+	seed := w.seed
+	n := len(b)
+	var a, c uint64
+	switch {
+	case n == 0:
+	case n < 4:
+		a = uint64(b[0])<<16 | uint64(b[n>>1])<<8 | uint64(b[n-1])
+	case n <= 16:
+		q := (n >> 3) << 2
+		a = wyr4(b)<<32 | wyr4(b[q:])
+		c = wyr4(b[n-4:])<<32 | wyr4(b[n-4-q:])
+	default:
+		p := b
+		if len(p) > 48 {
+			see1, see2 := seed, seed
+			for len(p) > 48 {
+				seed = wymix(wyr8(p)^wyp1, wyr8(p[8:])^seed)
+				see1 = wymix(wyr8(p[16:])^wyp2, wyr8(p[24:])^see1)
+				see2 = wymix(wyr8(p[32:])^wyp3, wyr8(p[40:])^see2)
+				p = p[48:]
+			}
+			seed ^= see1 ^ see2
+		}
+		for len(p) > 16 {
+			seed = wymix(wyr8(p)^wyp1, wyr8(p[8:])^seed)
+			p = p[16:]
+		}
+		// the last 16 bytes of b, they may overlap what was already mixed
+		a = wyr8(b[n-16:])
+		c = wyr8(b[n-8:])
+	}
+	a ^= wyp1
+	c ^= seed
+	c, a = bits.Mul64(a, c)
+	return wymix(a^wyp0^uint64(n), c^wyp1)
+}
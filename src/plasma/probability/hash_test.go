@@ -0,0 +1,96 @@
+package probability
+
+import (
+	"fmt"
+	"hash/fnv"
+	"testing"
+)
+
+func TestXXHash64_KnownValues(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+	}
+	for _, tc := range tests {
+		if got := XXHash64Sum([]byte(tc.in), 0); got != tc.want {
+			t.Fatalf("xxhash64(%q) = %x, want %x", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestHashers_SeedAndSpread(t *testing.T) {
+	hashers := map[string]func(seed uint64) Hasher{
+		"xxhash64": func(seed uint64) Hasher { return NewXXHash64(seed) },
+		"wyhash":   func(seed uint64) Hasher { return NewWyHash(seed) },
+	}
+	for name, newHasher := range hashers {
+		h1, h2 := newHasher(1), newHasher(2)
+		const (
+			keys    = 1 << 16
+			buckets = 64
+		)
+		counts := make([]int, buckets)
+		sameAcrossSeeds := 0
+		// lengths from 0 to 99 go through every code path
+		for i := range keys {
+			key := []byte(fmt.Sprintf("%0*d", i%100, i))
+			if h1.Hash(key) != h1.Hash(key) {
+				t.Fatalf("%s: not deterministic for %s", name, key)
+			}
+			if h1.Hash(key) == h2.Hash(key) {
+				sameAcrossSeeds++
+			}
+			counts[h1.Hash(key)%buckets]++
+		}
+		if sameAcrossSeeds > 0 {
+			t.Fatalf("%s: %d keys hash the same with different seeds", name, sameAcrossSeeds)
+		}
+		// every bucket should be within 10% of the mean
+		for i, c := range counts {
+			if c < keys/buckets*9/10 || c > keys/buckets*11/10 {
+				t.Fatalf("%s: bucket %d has %d keys, expected about %d", name, i, c, keys/buckets)
+			}
+		}
+	}
+}
+
+// --- benchmarks ---
+
+func benchmarkHasher(b *testing.B, h func([]byte) uint64) {
+	for _, size := range []int{8, 32, 256} {
+		key := make([]byte, size)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			var sink uint64
+			for i := 0; i < b.N; i++ {
+				sink += h(key)
+			}
+			_ = sink
+		})
+	}
+}
+
+// BenchmarkHash_FNV is how TSMap used to hash, a new fnv.New64a per call.
+func BenchmarkHash_FNV(b *testing.B) {
+	benchmarkHasher(b, func(key []byte) uint64 {
+		h := fnv.New64a()
+		h.Write(key)
+		return h.Sum64()
+	})
+}
+
+func BenchmarkHash_XXHash64(b *testing.B) {
+	benchmarkHasher(b, NewXXHash64(ProcessSeed).Hash)
+}
+
+func BenchmarkHash_WyHash(b *testing.B) {
+	benchmarkHasher(b, NewWyHash(ProcessSeed).Hash)
+}
@@ -0,0 +1,64 @@
+package probability
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// Source is a source of random uint64s that is safe for concurrent use.
+type Source interface {
+	Uint64() uint64
+}
+
+// SplitMix64 is a seedable Source, the state is a counter so concurrent callers only
+// contend on an atomic add and the same seed always gives the same sequence.
+type SplitMix64 struct {
+	state atomic.Uint64
+}
+
+func NewSplitMix64(seed uint64) *SplitMix64 {
+	s := &SplitMix64{}
+	s.state.Store(seed)
+	return s
+}
+
+func (s *SplitMix64) Uint64() uint64 {
+	z := s.state.Add(0x9e3779b97f4a7c15)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// LevelGenerator draws skiplist levels from a geometric distribution like Geometric,
+// but from its own Source.
+type LevelGenerator struct {
+	src Source
+	p   float64
+	// bitsPerLevel is 1 for p=1/2 and 2 for p=1/4, 0 for any other p
+	bitsPerLevel int
+}
+
+func NewLevelGenerator(p float64, src Source) *LevelGenerator {
+	g := &LevelGenerator{src: src, p: p}
+	switch p {
+	case 0.5:
+		g.bitsPerLevel = 1
+	case 0.25:
+		g.bitsPerLevel = 2
+	}
+	return g
+}
+
+// Level returns the number of successes before the first failure, each with probability p.
+func (g *LevelGenerator) Level() int {
+	if g.bitsPerLevel > 0 {
+		// every trailing zero bit is a success with p=1/2, every pair of them is one with p=1/4
+		return bits.TrailingZeros64(g.src.Uint64()) / g.bitsPerLevel
+	}
+	k := 0
+	// the top 53 bits make a uniform float in [0, 1)
+	for g.p > float64(g.src.Uint64()>>11)/(1<<53) {
+		k++
+	}
+	return k
+}
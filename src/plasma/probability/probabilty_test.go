@@ -27,3 +27,54 @@ func TestGeometricDist(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitMix64_Deterministic(t *testing.T) {
+	a, b := NewSplitMix64(42), NewSplitMix64(42)
+	other := NewSplitMix64(43)
+	same := 0
+	for range 1000 {
+		x := a.Uint64()
+		if x != b.Uint64() {
+			t.Fatalf("expected the same sequence for the same seed")
+		}
+		if x == other.Uint64() {
+			same++
+		}
+	}
+	if same > 0 {
+		t.Fatalf("expected different sequences for different seeds, %d equal draws", same)
+	}
+}
+
+func TestLevelGenerator_Distribution(t *testing.T) {
+	const trials = 1 << 16
+	for _, p := range []float64{0.5, 0.25, 0.3} {
+		g := NewLevelGenerator(p, NewSplitMix64(7))
+		dist := make(map[int]int)
+		for range trials {
+			dist[g.Level()]++
+		}
+		// P(level >= k) = p^k, check the first few levels within 10%
+		atLeast := trials
+		for k := 0; k < 4; k++ {
+			want := float64(trials) * math.Pow(p, float64(k))
+			if math.Abs(float64(atLeast)-want) > want/10 {
+				t.Fatalf("p=%v: %d levels >= %d, want about %.0f", p, atLeast, k, want)
+			}
+			atLeast -= dist[k]
+		}
+	}
+}
+
+func BenchmarkGeometric(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Geometric(0.5)
+	}
+}
+
+func BenchmarkLevelGenerator(b *testing.B) {
+	g := NewLevelGenerator(0.5, NewSplitMix64(1))
+	for i := 0; i < b.N; i++ {
+		g.Level()
+	}
+}
@@ -0,0 +1,36 @@
+package types
+
+import "bytes"
+
+// Comparator defines the key order of the skiplist (and of everything sorted after it).
+// Name is persisted so a db can't be opened with a different order than it was written in.
+// Keys that compare equal must also be byte equal, the memtable map looks them up by bytes.
+type Comparator interface {
+	Compare(a, b []byte) int
+	Name() string
+}
+
+var (
+	BytewiseComparator        Comparator = bytewise{}
+	ReverseBytewiseComparator Comparator = reverseBytewise{}
+)
+
+type bytewise struct{}
+
+func (bytewise) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewise) Name() string {
+	return "plasma.BytewiseComparator"
+}
+
+type reverseBytewise struct{}
+
+func (reverseBytewise) Compare(a, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+func (reverseBytewise) Name() string {
+	return "plasma.ReverseBytewiseComparator"
+}
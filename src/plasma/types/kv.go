@@ -15,9 +15,22 @@ type VersionedValue struct {
 	Version uint64
 }
 
+type KVIterator interface {
+	Next()
+	// Dref returns nil once the iterator is exhausted
+	Dref() *KV
+	// Versions returns every retained version of the current key, newest first,
+	// for the snapshots that still read older versions
+	Versions() []VersionedValue
+}
+
 type KVDB interface {
-	Put(kv *KV)
+	Put(kv *KV) error
 	Get(key []byte) (VersionedValue, bool)
 	Size() uint64
+	// MemoryUsage is the memory held by the store, including its own overhead
+	MemoryUsage() uint64
+	// Seal stops further Puts and returns an iterator over everything put so far, in key order
+	Seal() KVIterator
 	//TODO multiput
 }
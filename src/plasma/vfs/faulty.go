@@ -0,0 +1,159 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+var ErrInjected = errors.New("vfs: injected fault")
+
+type Op int
+
+const (
+	OpCreate Op = iota
+	OpOpen
+	OpRename
+	OpRemove
+	OpLock
+	OpList
+	OpRead
+	OpWrite
+	OpSync
+	OpClose
+	OpSyncDir
+)
+
+// Injector is called before every operation, a non nil error fails the operation
+// without it reaching the wrapped FS.
+type Injector func(op Op, name string) error
+
+// FailAfter returns an Injector that lets n of the given ops through (all ops if none are given)
+// and fails every matching op after that, like a disk that died.
+func FailAfter(n int64, ops ...Op) Injector {
+	var count atomic.Int64
+	return func(op Op, name string) error {
+		if len(ops) > 0 {
+			match := false
+			for _, o := range ops {
+				match = match || o == op
+			}
+			if !match {
+				return nil
+			}
+		}
+		if count.Add(1) > n {
+			return ErrInjected
+		}
+		return nil
+	}
+}
+
+// FaultyFS wraps an FS and fails operations at the points chosen by its Injector.
+// It doesn't drop anything itself, wrapping a MemFS and calling its CrashClone after a failure gives
+// the state after a power loss, unsynced data and unsynced directory changes rolled back.
+type FaultyFS struct {
+	fs     FS
+	inject Injector
+}
+
+func NewFaultyFS(fs FS, inject Injector) *FaultyFS {
+	return &FaultyFS{fs: fs, inject: inject}
+}
+
+func (f *FaultyFS) Create(name string) (File, error) {
+	if err := f.inject(OpCreate, name); err != nil {
+		return nil, err
+	}
+	file, err := f.fs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, inject: f.inject}, nil
+}
+
+func (f *FaultyFS) Open(name string) (File, error) {
+	if err := f.inject(OpOpen, name); err != nil {
+		return nil, err
+	}
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{File: file, inject: f.inject}, nil
+}
+
+func (f *FaultyFS) Rename(oldname, newname string) error {
+	if err := f.inject(OpRename, oldname); err != nil {
+		return err
+	}
+	return f.fs.Rename(oldname, newname)
+}
+
+func (f *FaultyFS) Remove(name string) error {
+	if err := f.inject(OpRemove, name); err != nil {
+		return err
+	}
+	return f.fs.Remove(name)
+}
+
+func (f *FaultyFS) Lock(name string) (io.Closer, error) {
+	if err := f.inject(OpLock, name); err != nil {
+		return nil, err
+	}
+	return f.fs.Lock(name)
+}
+
+func (f *FaultyFS) List(dir string) ([]string, error) {
+	if err := f.inject(OpList, dir); err != nil {
+		return nil, err
+	}
+	return f.fs.List(dir)
+}
+
+func (f *FaultyFS) SyncDir(dir string) error {
+	if err := f.inject(OpSyncDir, dir); err != nil {
+		return err
+	}
+	return f.fs.SyncDir(dir)
+}
+
+type faultyFile struct {
+	File
+	inject Injector
+}
+
+func (f *faultyFile) Read(p []byte) (int, error) {
+	if err := f.inject(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *faultyFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.inject(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if err := f.inject(OpWrite, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if err := f.inject(OpSync, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultyFile) Close() error {
+	if err := f.inject(OpClose, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Close()
+}
@@ -0,0 +1,230 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemFS is an in memory FS for tests. Like a real disk, file contents are durable once synced
+// and creates, renames and removes once their directory is synced with SyncDir.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memNode
+	// durable is the entries as of the last SyncDir of their directory, what a crash leaves
+	durable map[string]*memNode
+	locked  map[string]bool
+}
+
+type memNode struct {
+	mu     sync.Mutex
+	data   []byte
+	synced []byte
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memNode), durable: make(map[string]*memNode), locked: make(map[string]bool)}
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	name = path.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := &memNode{}
+	m.files[name] = n
+	return &memFile{name: name, node: n, writable: true}, nil
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	name = path.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &memFile{name: name, node: n}, nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	oldname, newname = path.Clean(oldname), path.Clean(newname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.files[oldname]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	delete(m.files, oldname)
+	m.files[newname] = n
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = path.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) Lock(name string) (io.Closer, error) {
+	name = path.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locked[name] {
+		return nil, ErrLocked
+	}
+	m.locked[name] = true
+	if _, ok := m.files[name]; !ok {
+		m.files[name] = &memNode{}
+	}
+	return &memLock{fs: m, name: name}, nil
+}
+
+func (m *MemFS) List(dir string) ([]string, error) {
+	dir = path.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool)
+	for name := range m.files {
+		rel := name
+		if dir != "." {
+			if !strings.HasPrefix(name, dir+"/") {
+				continue
+			}
+			rel = name[len(dir)+1:]
+		}
+		// nested files show up as their top level directory, like os.ReadDir
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			rel = rel[:i]
+		}
+		seen[rel] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MemFS) SyncDir(dir string) error {
+	dir = path.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range m.durable {
+		if _, ok := m.files[name]; !ok && path.Dir(name) == dir {
+			delete(m.durable, name)
+		}
+	}
+	for name, n := range m.files {
+		if path.Dir(name) == dir {
+			m.durable[name] = n
+		}
+	}
+	return nil
+}
+
+// CrashClone returns a copy of the FS as it would look after a power loss: only the entries of
+// synced directories exist, under the names they had at that sync, and every file keeps only what
+// was synced. Locks are not carried over.
+func (m *MemFS) CrashClone() *MemFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := NewMemFS()
+	for name, n := range m.durable {
+		n.mu.Lock()
+		cn := &memNode{data: append([]byte(nil), n.synced...), synced: append([]byte(nil), n.synced...)}
+		n.mu.Unlock()
+		c.files[name] = cn
+		c.durable[name] = cn
+	}
+	return c
+}
+
+type memLock struct {
+	fs   *MemFS
+	name string
+}
+
+func (l *memLock) Close() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	delete(l.fs.locked, l.name)
+	return nil
+}
+
+type memFile struct {
+	name     string
+	node     *memNode
+	offset   int64
+	writable bool
+	closed   bool
+}
+
+var errClosed = errors.New("vfs: file already closed")
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, errClosed
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write always appends, the tables and the WAL are written sequentially.
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, errClosed
+	}
+	if !f.writable {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	f.node.data = append(f.node.data, p...)
+	return len(p), nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed {
+		return errClosed
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	f.node.synced = append(f.node.synced[:0], f.node.data...)
+	return nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return errClosed
+	}
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,36 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrLocked = errors.New("vfs: file is already locked")
+
+// File is what the WAL, tables and manifest write to and read from.
+// Sync is the only durability point of the data, anything written after the last Sync may be lost on a crash.
+// The file itself only survives a crash once its directory was synced with FS.SyncDir.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	Sync() error
+	Name() string
+}
+
+// FS decouples storage from the os package, so tests can run against memory
+// and inject faults.
+type FS interface {
+	Create(name string) (File, error)
+	Open(name string) (File, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	// Lock takes an exclusive lock on name, the lock is released by closing the returned Closer.
+	Lock(name string) (io.Closer, error)
+	// List returns the names of the entries in dir, sorted.
+	List(dir string) ([]string, error)
+	// SyncDir makes the creates, renames and removes in dir durable, like fsync on the directory.
+	// Without it a renamed MANIFEST can come back under its old name after a crash.
+	SyncDir(dir string) error
+}
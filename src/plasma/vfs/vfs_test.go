@@ -0,0 +1,169 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, fs FS, name string, data []byte, sync bool) File {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatalf("Create(%s): %v", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write(%s): %v", name, err)
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			t.Fatalf("Sync(%s): %v", name, err)
+		}
+	}
+	return f
+}
+
+func readFile(t *testing.T, fs FS, name string) []byte {
+	t.Helper()
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll(%s): %v", name, err)
+	}
+	return data
+}
+
+func testFS(t *testing.T, fs FS, dir string) {
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFile(t, fs, a, []byte("hello"), true).Close()
+	if got := readFile(t, fs, a); !bytes.Equal(got, []byte("hello")) {
+		t.Fatalf("expected hello got %s", got)
+	}
+	if err := fs.Rename(a, b); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := fs.SyncDir(dir); err != nil {
+		t.Fatalf("SyncDir: %v", err)
+	}
+	if _, err := fs.Open(a); err == nil {
+		t.Fatalf("expected %s to be gone after rename", a)
+	}
+	names, err := fs.List(dir)
+	if err != nil || !reflect.DeepEqual(names, []string{"b"}) {
+		t.Fatalf("List = %v %v, want [b]", names, err)
+	}
+	l, err := fs.Lock(filepath.Join(dir, "LOCK"))
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := fs.Lock(filepath.Join(dir, "LOCK")); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked got %v", err)
+	}
+	l.Close()
+	if l, err = fs.Lock(filepath.Join(dir, "LOCK")); err != nil {
+		t.Fatalf("Lock after release: %v", err)
+	}
+	l.Close()
+	if err := fs.Remove(b); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+}
+
+func TestOSFS(t *testing.T) {
+	testFS(t, Default, t.TempDir())
+}
+
+func TestMemFS(t *testing.T) {
+	testFS(t, NewMemFS(), "db")
+}
+
+func TestMemFS_CrashCloneDropsUnsynced(t *testing.T) {
+	fs := NewMemFS()
+	f := writeFile(t, fs, "wal", []byte("synced"), true)
+	f.Write([]byte("-lost"))
+	writeFile(t, fs, "never-synced", []byte("lost"), false)
+	if err := fs.SyncDir("."); err != nil {
+		t.Fatalf("SyncDir: %v", err)
+	}
+
+	crashed := fs.CrashClone()
+	if got := readFile(t, fs, "wal"); !bytes.Equal(got, []byte("synced-lost")) {
+		t.Fatalf("expected the original fs to keep everything, got %s", got)
+	}
+	if got := readFile(t, crashed, "wal"); !bytes.Equal(got, []byte("synced")) {
+		t.Fatalf("expected synced got %s", got)
+	}
+	if got := readFile(t, crashed, "never-synced"); len(got) != 0 {
+		t.Fatalf("expected empty file got %s", got)
+	}
+}
+
+func TestFaultyFS_FailAfter(t *testing.T) {
+	mem := NewMemFS()
+	fs := NewFaultyFS(mem, FailAfter(1, OpSync))
+	f := writeFile(t, fs, "wal", []byte("first"), true)
+	if err := fs.SyncDir("."); err != nil {
+		t.Fatalf("SyncDir: %v", err)
+	}
+	f.Write([]byte("second"))
+	if err := f.Sync(); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected ErrInjected got %v", err)
+	}
+	if got := readFile(t, mem.CrashClone(), "wal"); !bytes.Equal(got, []byte("first")) {
+		t.Fatalf("expected first got %s", got)
+	}
+}
+
+func TestMemFS_CrashCloneRollsBackUnsyncedDir(t *testing.T) {
+	fs := NewMemFS()
+	writeFile(t, fs, "MANIFEST-1", []byte("v1"), true).Close()
+	writeFile(t, fs, "db/000001.log", []byte("wal"), true).Close()
+	if err := fs.SyncDir("."); err != nil {
+		t.Fatalf("SyncDir: %v", err)
+	}
+	if err := fs.SyncDir("db"); err != nil {
+		t.Fatalf("SyncDir: %v", err)
+	}
+
+	// a new manifest installed by rename, and a removed log, neither with its directory synced
+	writeFile(t, fs, "MANIFEST-2.tmp", []byte("v2"), true).Close()
+	if err := fs.Rename("MANIFEST-2.tmp", "MANIFEST-2"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := fs.Remove("MANIFEST-1"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := fs.Remove("db/000001.log"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	crashed := fs.CrashClone()
+	if names, _ := crashed.List("."); !reflect.DeepEqual(names, []string{"MANIFEST-1", "db"}) {
+		t.Fatalf("expected the unsynced rename and remove to be rolled back, got %v", names)
+	}
+	if got := readFile(t, crashed, "db/000001.log"); !bytes.Equal(got, []byte("wal")) {
+		t.Fatalf("expected wal got %s", got)
+	}
+
+	// syncing the root doesn't make the remove in db durable
+	if err := fs.SyncDir("."); err != nil {
+		t.Fatalf("SyncDir: %v", err)
+	}
+	crashed = fs.CrashClone()
+	if names, _ := crashed.List("."); !reflect.DeepEqual(names, []string{"MANIFEST-2", "db"}) {
+		t.Fatalf("expected [MANIFEST-2 db] got %v", names)
+	}
+	if got := readFile(t, crashed, "MANIFEST-2"); !bytes.Equal(got, []byte("v2")) {
+		t.Fatalf("expected v2 got %s", got)
+	}
+	if _, err := crashed.Open("db/000001.log"); err != nil {
+		t.Fatalf("expected the removed log to come back, got %v", err)
+	}
+}